@@ -3,32 +3,41 @@ package auth
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
+
+	"github.com/replicate/yolo/pkg/errdefs"
 )
 
 type responseBody struct {
 	Username string `json:"username"`
 }
 
-func VerifyCogToken(token string) (username string, err error) {
+// VerifyCogToken returns the user token belongs to.  Its request is traced
+// to log, which may be nil.
+func VerifyCogToken(log *slog.Logger, registry string, token string) (username string, err error) {
 	if token == "" {
-		return "", fmt.Errorf("token is required")
+		return "", errdefs.Errorf(errdefs.InvalidInput, "token is required")
 	}
 
-	resp, err := http.PostForm("https://r8.im/cog/v1/verify-token", url.Values{
+	resp, err := httpClient(log).PostForm("https://"+registry+"/cog/v1/verify-token", url.Values{
 		"token": []string{token},
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to verify token: %w", err)
+		return "", errdefs.Wrap(errdefs.Retryable, fmt.Errorf("failed to verify token: %w", err))
 	}
+	defer resp.Body.Close()
+
 	if resp.StatusCode == http.StatusNotFound {
-		return "", fmt.Errorf("user does not exist")
+		return "", errdefs.Errorf(errdefs.Auth, "user does not exist")
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return "", errdefs.Errorf(errdefs.Auth, "invalid token")
 	}
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to verify token, got status %d", resp.StatusCode)
+		return "", errdefs.FromStatus(resp.StatusCode, fmt.Errorf("failed to verify token, got status %d", resp.StatusCode))
 	}
-	defer resp.Body.Close()
 
 	body := &responseBody{}
 	if err := json.NewDecoder(resp.Body).Decode(body); err != nil {
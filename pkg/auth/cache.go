@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// VerifiedTTL is how long a verified token is trusted before it is checked
+// against the registry again
+var VerifiedTTL = 24 * time.Hour
+
+type verifiedToken struct {
+	Username string    `json:"username"`
+	Expires  time.Time `json:"expires"`
+}
+
+// VerifyCogTokenCached is VerifyCogToken, but skips the round trip if the
+// token was verified within VerifiedTTL
+func VerifyCogTokenCached(log *slog.Logger, registry string, token string) (string, error) {
+	cache, err := loadVerified()
+	if err == nil {
+		if v, ok := cache[tokenKey(token)]; ok && time.Now().Before(v.Expires) {
+			return v.Username, nil
+		}
+	}
+
+	username, err := VerifyCogToken(log, registry, token)
+	if err != nil {
+		return "", err
+	}
+
+	// failing to cache only costs another round trip next time
+	_ = cacheUsername(token, username)
+
+	return username, nil
+}
+
+func cacheUsername(token string, username string) error {
+	cache, err := loadVerified()
+	if err != nil {
+		cache = make(map[string]verifiedToken)
+	}
+
+	now := time.Now()
+	for k, v := range cache {
+		if now.After(v.Expires) {
+			delete(cache, k)
+		}
+	}
+	cache[tokenKey(token)] = verifiedToken{Username: username, Expires: now.Add(VerifiedTTL)}
+
+	return saveVerified(cache)
+}
+
+func forgetUsername(token string) error {
+	cache, err := loadVerified()
+	if err != nil {
+		return nil
+	}
+
+	delete(cache, tokenKey(token))
+	return saveVerified(cache)
+}
+
+// tokens are never written to the cache, only their hashes
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func verifiedPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "yolo", "verified-tokens.json"), nil
+}
+
+func loadVerified() (map[string]verifiedToken, error) {
+	path, err := verifiedPath()
+	if err != nil {
+		return nil, err
+	}
+
+	cache := make(map[string]verifiedToken)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+func saveVerified(cache map[string]verifiedToken) error {
+	path, err := verifiedPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
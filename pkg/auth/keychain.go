@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// ReplicateRegistry is the registry that accepts Replicate tokens
+const ReplicateRegistry = "r8.im"
+
+type tokenKeychain struct {
+	registry string
+	auth     authn.Authenticator
+}
+
+// NewKeychain uses auth for registry and falls back to the Docker keychain
+// (docker config and credential helpers) for every other host
+func NewKeychain(registry string, auth authn.Authenticator) authn.Keychain {
+	return authn.NewMultiKeychain(&tokenKeychain{registry: registry, auth: auth}, authn.DefaultKeychain)
+}
+
+func (k *tokenKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if target.RegistryStr() != k.registry {
+		return authn.Anonymous, nil
+	}
+	return k.auth, nil
+}
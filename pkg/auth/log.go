@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/replicate/yolo/pkg/logging"
+)
+
+// orDiscard returns log, or a logger that drops everything if it is nil
+func orDiscard(log *slog.Logger) *slog.Logger {
+	if log == nil {
+		return slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return log
+}
+
+// httpClient traces its requests to log
+func httpClient(log *slog.Logger) *http.Client {
+	return &http.Client{Transport: logging.Transport(http.DefaultTransport, orDiscard(log))}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/types"
+)
+
+// Login verifies token and stores it in the Docker config (or its
+// credential helper) so authn.DefaultKeychain picks it up
+func Login(log *slog.Logger, registry string, token string) (username string, err error) {
+	username, err = VerifyCogToken(log, registry, token)
+	if err != nil {
+		return "", err
+	}
+
+	cf, err := config.Load(config.Dir())
+	if err != nil {
+		return "", fmt.Errorf("loading docker config: %w", err)
+	}
+
+	err = cf.GetCredentialsStore(registry).Store(types.AuthConfig{
+		ServerAddress: registry,
+		Username:      username,
+		Password:      token,
+	})
+	if err != nil {
+		return "", fmt.Errorf("storing credentials: %w", err)
+	}
+
+	if err := cacheUsername(token, username); err != nil {
+		return "", err
+	}
+
+	return username, nil
+}
+
+// Logout removes stored credentials for registry
+func Logout(registry string) error {
+	cf, err := config.Load(config.Dir())
+	if err != nil {
+		return fmt.Errorf("loading docker config: %w", err)
+	}
+
+	store := cf.GetCredentialsStore(registry)
+	creds, err := store.Get(registry)
+	if err != nil {
+		return fmt.Errorf("reading credentials: %w", err)
+	}
+	if creds.Password != "" {
+		if err := forgetUsername(creds.Password); err != nil {
+			return err
+		}
+	}
+
+	if err := store.Erase(registry); err != nil {
+		return fmt.Errorf("removing credentials: %w", err)
+	}
+
+	return nil
+}
@@ -3,12 +3,15 @@ package auth
 import (
 	"bytes"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 )
 
-func MakeSamples(image_id string,
+// MakeSamples submits every json file in sampleDir, reporting each to log
+func MakeSamples(log *slog.Logger,
+	image_id string,
 	sampleDir string,
 	token string,
 	baseApi string) error {
@@ -26,8 +29,8 @@ func MakeSamples(image_id string,
 			continue
 		}
 		samplePath := filepath.Join(sampleDir, sampleFile.Name())
-		fmt.Println("running sample:", samplePath)
-		err = RunSample(samplePath, image_id, token, baseApi)
+		orDiscard(log).Info("running sample", "path", samplePath)
+		err = RunSample(log, samplePath, image_id, token, baseApi)
 		if err != nil {
 			return err
 		}
@@ -36,7 +39,8 @@ func MakeSamples(image_id string,
 	return nil
 }
 
-func RunSample(samplePath string,
+func RunSample(log *slog.Logger,
+	samplePath string,
 	image_id string,
 	token string,
 	baseApi string,
@@ -56,13 +60,13 @@ func RunSample(samplePath string,
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Token %s", token))
 
-	client := &http.Client{}
+	client := httpClient(log)
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	fmt.Println("Status:", resp.Status)
+	orDiscard(log).Info("sample submitted", "path", samplePath, "status", resp.Status)
 	return nil
 }
@@ -0,0 +1,416 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	gcache "github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// kinds of cached blobs, each kept in its own directory
+const (
+	Manifests = "manifests"
+	Configs   = "configs"
+	Layers    = "layers"
+)
+
+var kinds = []string{Manifests, Configs, Layers}
+
+const (
+	// temporary files are only renamed into place once their digest checks out
+	tmpPrefix = "tmp-"
+
+	// how much of a partly read layer is read on close to cache it
+	drainLimit = 1 << 20
+
+	// a layer's media type is kept next to it, in a file with this suffix
+	mediaTypeSuffix = ".type"
+)
+
+// Cache is a content-addressed store of manifests, configs and layers.
+// Entries are named by digest and only ever written once their content
+// matches it, so a cached entry can always be trusted.
+type Cache struct {
+	Dir string
+	// MaxSize is trimmed to after every write, 0 for no limit
+	MaxSize int64
+}
+
+// Entry is one cached blob
+type Entry struct {
+	Kind     string    `json:"kind"`
+	Digest   string    `json:"digest"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"last_used"`
+
+	path string
+}
+
+// DefaultDir is yolo's directory in the user cache dir, like ~/.cache/yolo
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "yolo"), nil
+}
+
+func New(dir string, maxSize int64) *Cache {
+	return &Cache{Dir: dir, MaxSize: maxSize}
+}
+
+func (c *Cache) path(kind string, h v1.Hash) string {
+	return filepath.Join(c.Dir, kind, h.Algorithm+"-"+h.Hex)
+}
+
+// Get returns a cached manifest or config
+func (c *Cache) Get(kind string, h v1.Hash) ([]byte, error) {
+	p := c.path(kind, h)
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, gcache.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	touch(p)
+	return b, nil
+}
+
+// Put caches a manifest or config, if it matches h
+func (c *Cache) Put(kind string, h v1.Hash, b []byte) error {
+	w, err := c.create(kind, h)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return err
+	}
+	w.complete = true
+	return w.Close()
+}
+
+// LayerCache returns the layer store for ggcr's cache.Image.  Layers are
+// kept compressed, by digest, so a cached layer is exactly the blob the
+// registry holds.
+func (c *Cache) LayerCache() gcache.Cache {
+	return &layerCache{c: c}
+}
+
+// List returns every cache entry, least recently used first
+func (c *Cache) List() ([]Entry, error) {
+	var entries []Entry
+	for _, kind := range kinds {
+		files, err := os.ReadDir(filepath.Join(c.Dir, kind))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			if f.IsDir() || strings.HasPrefix(f.Name(), tmpPrefix) || strings.HasSuffix(f.Name(), mediaTypeSuffix) {
+				continue
+			}
+			info, err := f.Info()
+			if err != nil {
+				continue
+			}
+			entries = append(entries, Entry{
+				Kind:     kind,
+				Digest:   strings.Replace(f.Name(), "-", ":", 1),
+				Size:     info.Size(),
+				LastUsed: info.ModTime(),
+				path:     filepath.Join(c.Dir, kind, f.Name()),
+			})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.Before(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// Prune removes entries unused for olderThan, if it is set, then the least
+// recently used entries until the cache fits in maxSize, if it is not
+// negative.  It returns what was removed.
+func (c *Cache) Prune(maxSize int64, olderThan time.Duration) ([]Entry, error) {
+	c.removeStaleTemps()
+
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+
+	var removed []Entry
+	for _, e := range entries {
+		old := olderThan > 0 && time.Since(e.LastUsed) > olderThan
+		tooBig := maxSize >= 0 && total > maxSize
+		if !old && !tooBig {
+			continue
+		}
+		if err := os.Remove(e.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, err
+		}
+		os.Remove(e.path + mediaTypeSuffix)
+		total -= e.Size
+		removed = append(removed, e)
+	}
+	return removed, nil
+}
+
+// trim keeps the cache within MaxSize
+func (c *Cache) trim() {
+	if c.MaxSize > 0 {
+		// the cache is best effort, a failed trim is retried after the next write
+		_, _ = c.Prune(c.MaxSize, 0)
+	}
+}
+
+// temporary files left by killed processes
+func (c *Cache) removeStaleTemps() {
+	for _, kind := range kinds {
+		matches, _ := filepath.Glob(filepath.Join(c.Dir, kind, tmpPrefix+"*"))
+		for _, m := range matches {
+			if info, err := os.Stat(m); err == nil && time.Since(info.ModTime()) > time.Hour {
+				os.Remove(m)
+			}
+		}
+	}
+}
+
+// create returns a writer that moves its content into the cache on Close,
+// if the whole blob was written and it matches h
+func (c *Cache) create(kind string, h v1.Hash) (*blobWriter, error) {
+	if h.Algorithm != "sha256" {
+		return nil, fmt.Errorf("can't cache %s blobs", h.Algorithm)
+	}
+
+	dir := filepath.Join(c.Dir, kind)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, tmpPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	return &blobWriter{c: c, f: f, hash: sha256.New(), want: h, dest: c.path(kind, h)}, nil
+}
+
+type blobWriter struct {
+	c    *Cache
+	f    *os.File
+	hash hash.Hash
+	want v1.Hash
+	dest string
+
+	// complete is set once the whole blob was written
+	complete bool
+	// mediaType is kept with the blob if it is set
+	mediaType types.MediaType
+}
+
+func (w *blobWriter) Write(b []byte) (int, error) {
+	w.hash.Write(b)
+	return w.f.Write(b)
+}
+
+func (w *blobWriter) Close() error {
+	err := w.f.Close()
+	if err == nil && w.complete && hex.EncodeToString(w.hash.Sum(nil)) == w.want.Hex {
+		// the media type goes first, so a cached layer always has one
+		if w.mediaType != "" {
+			err = os.WriteFile(w.dest+mediaTypeSuffix, []byte(w.mediaType), 0600)
+		}
+		if err == nil {
+			err = os.Rename(w.f.Name(), w.dest)
+		}
+		if err == nil {
+			w.c.trim()
+			return nil
+		}
+	}
+	os.Remove(w.f.Name())
+	return err
+}
+
+type layerCache struct {
+	c *Cache
+}
+
+// Put returns a layer read from the cache if it is there, and cached as it
+// is read otherwise.  Its digest, diff id and media type are still l's.
+func (lc *layerCache) Put(l v1.Layer) (v1.Layer, error) {
+	return &layer{Layer: l, c: lc.c}, nil
+}
+
+// Get only finds layers by digest, uncompressed reads go through Put.
+// Layers cached without their media type are fetched again.
+func (lc *layerCache) Get(h v1.Hash) (v1.Layer, error) {
+	p := lc.c.path(Layers, h)
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, gcache.ErrNotFound
+	}
+	mt, err := os.ReadFile(p + mediaTypeSuffix)
+	if err != nil {
+		return nil, gcache.ErrNotFound
+	}
+	return partial.CompressedToLayer(&fileLayer{c: lc.c, digest: h, size: info.Size(), mediaType: types.MediaType(mt)})
+}
+
+func (lc *layerCache) Delete(h v1.Hash) error {
+	p := lc.c.path(Layers, h)
+	os.Remove(p + mediaTypeSuffix)
+	err := os.Remove(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return gcache.ErrNotFound
+	}
+	return err
+}
+
+// open returns a cached layer
+func (c *Cache) open(h v1.Hash) (io.ReadCloser, error) {
+	p := c.path(Layers, h)
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	touch(p)
+	return f, nil
+}
+
+// layer reads a layer from the cache, or caches it as it is read
+type layer struct {
+	v1.Layer
+	c *Cache
+}
+
+func (l *layer) Compressed() (io.ReadCloser, error) {
+	digest, err := l.Layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if rc, err := l.c.open(digest); err == nil {
+		return rc, nil
+	}
+
+	mt, err := l.Layer.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	rc, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	return l.c.tee(rc, digest, mt), nil
+}
+
+// Uncompressed decompresses the cached blob, so that only compressed layers
+// are ever kept
+func (l *layer) Uncompressed() (io.ReadCloser, error) {
+	ul, err := partial.CompressedToLayer(compressedLayer{l})
+	if err != nil {
+		return nil, err
+	}
+	return ul.Uncompressed()
+}
+
+// compressedLayer hides layer's Uncompressed from partial
+type compressedLayer struct {
+	partial.CompressedLayer
+}
+
+// fileLayer is a cached layer found by digest alone
+type fileLayer struct {
+	c         *Cache
+	digest    v1.Hash
+	size      int64
+	mediaType types.MediaType
+}
+
+func (l *fileLayer) Digest() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+func (l *fileLayer) Size() (int64, error) {
+	return l.size, nil
+}
+
+func (l *fileLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
+
+func (l *fileLayer) Compressed() (io.ReadCloser, error) {
+	return l.c.open(l.digest)
+}
+
+// tee copies rc into the layer cache as it is read.  Caching is best
+// effort: if the cache can't be written, rc is returned as is.
+func (c *Cache) tee(rc io.ReadCloser, h v1.Hash, mt types.MediaType) io.ReadCloser {
+	w, err := c.create(Layers, h)
+	if err != nil {
+		return rc
+	}
+	w.mediaType = mt
+	return &teeReader{rc: rc, w: w}
+}
+
+type teeReader struct {
+	rc io.ReadCloser
+	w  *blobWriter
+	// failed is set if the cache couldn't be written, the read goes on
+	failed bool
+}
+
+func (t *teeReader) Read(b []byte) (int, error) {
+	n, err := t.rc.Read(b)
+	if n > 0 && !t.failed {
+		if _, werr := t.w.Write(b[:n]); werr != nil {
+			t.failed = true
+		}
+	}
+	if err == io.EOF && !t.failed {
+		t.w.complete = true
+	}
+	return n, err
+}
+
+func (t *teeReader) Close() error {
+	// tar readers stop at the end of archive marker, short of the padding
+	// after it; read that too so the layer can be verified and kept
+	if !t.w.complete && !t.failed {
+		io.CopyN(io.Discard, t, drainLimit)
+	}
+
+	err := t.rc.Close()
+	// a blob that couldn't be cached is simply fetched again next time
+	_ = t.w.Close()
+	return err
+}
+
+// touch marks an entry as recently used
+func touch(p string) {
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+}
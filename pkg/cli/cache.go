@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/replicate/yolo/pkg/cache"
+	"github.com/replicate/yolo/pkg/errdefs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cacheDir   string
+	cacheSize  = byteSize(10 * humanize.GByte)
+	noCache    bool
+	pruneAll   bool
+	pruneSize  byteSize
+	pruneOlder time.Duration
+)
+
+func newCacheCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "cache",
+		Short:  "inspect and clean the local image cache",
+		Hidden: false,
+	}
+
+	ls := &cobra.Command{
+		Use:   "ls",
+		Short: "list cached manifests, configs and layers",
+		RunE:  cacheLsCommmand,
+		Args:  cobra.ExactArgs(0),
+	}
+
+	prune := &cobra.Command{
+		Use:   "prune",
+		Short: "remove cached entries, least recently used first",
+		RunE:  cachePruneCommmand,
+		Args:  cobra.ExactArgs(0),
+	}
+	prune.Flags().BoolVar(&pruneAll, "all", false, "remove everything")
+	prune.Flags().Var(&pruneSize, "max-size", "shrink the cache to this size, defaults to --cache-size")
+	prune.Flags().DurationVar(&pruneOlder, "older-than", 0, "remove entries unused for this long, like 168h")
+
+	cmd.AddCommand(ls, prune)
+	return cmd
+}
+
+// setupCache points cfg at the cache, unless --no-cache is set
+func setupCache() {
+	cfg.Cache = nil
+	if noCache {
+		return
+	}
+
+	dir := cacheDir
+	if dir == "" {
+		var err error
+		dir, err = cache.DefaultDir()
+		if err != nil {
+			// without a cache dir yolo just works uncached
+			return
+		}
+	}
+	cfg.Cache = cache.New(dir, int64(cacheSize))
+}
+
+func openCache() (*cache.Cache, error) {
+	if cfg.Cache == nil {
+		return nil, errdefs.Errorf(errdefs.InvalidInput, "the cache is disabled")
+	}
+	return cfg.Cache, nil
+}
+
+func cacheLsCommmand(cmd *cobra.Command, args []string) error {
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+
+	entries, err := c.List()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+
+	return printResult(struct {
+		Dir     string        `json:"dir"`
+		Size    int64         `json:"size"`
+		Entries []cache.Entry `json:"entries"`
+	}{c.Dir, total, entries}, func() {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tDIGEST\tSIZE\tLAST USED")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Kind, e.Digest, humanize.Bytes(uint64(e.Size)), humanize.Time(e.LastUsed))
+		}
+		w.Flush()
+		fmt.Printf("%d entries, %s in %s\n", len(entries), humanize.Bytes(uint64(total)), c.Dir)
+	})
+}
+
+func cachePruneCommmand(cmd *cobra.Command, args []string) error {
+	c, err := openCache()
+	if err != nil {
+		return err
+	}
+
+	maxSize := int64(-1)
+	switch {
+	case pruneAll:
+		maxSize = 0
+	case cmd.Flags().Changed("max-size"):
+		maxSize = int64(pruneSize)
+	case pruneOlder == 0:
+		maxSize = c.MaxSize
+	}
+
+	removed, err := c.Prune(maxSize, pruneOlder)
+	if err != nil {
+		return err
+	}
+
+	var freed int64
+	for _, e := range removed {
+		freed += e.Size
+	}
+
+	return printResult(struct {
+		Removed int   `json:"removed"`
+		Freed   int64 `json:"freed"`
+	}{len(removed), freed}, func() {
+		fmt.Printf("removed %d entries, freed %s\n", len(removed), humanize.Bytes(uint64(freed)))
+	})
+}
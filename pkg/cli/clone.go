@@ -2,12 +2,19 @@ package cli
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/replicate/yolo/pkg/images"
 	"github.com/spf13/cobra"
 )
 
+var (
+	cloneDests  []string
+	stripYolo   bool
+	resetConfig bool
+	cloneLabel  string
+	cloneMarker string
+)
+
 func newCloneCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:    "clone",
@@ -19,7 +26,12 @@ func newCloneCommand() *cobra.Command {
 
 	cmd.Flags().StringVarP(&sToken, "token", "t", "", "replicate api token")
 	cmd.Flags().StringVarP(&baseRef, "base", "b", "", "base image reference.  examples: owner/model or r8.im/owner/model@sha256:hexdigest")
-	cmd.Flags().StringVarP(&dest, "dest", "d", "", "destination image. examples: owner/model or r8.im/owner/model")
+	cmd.Flags().StringArrayVarP(&cloneDests, "dest", "d", nil, "destination image, repeat to clone to several. examples: owner/model or r8.im/owner/model")
+	cmd.Flags().BoolVar(&skipPreflight, "skip-preflight", false, "don't check the destination and base before cloning")
+	cmd.Flags().BoolVar(&stripYolo, "strip-yolo", false, "remove every yolo layer from the clone")
+	cmd.Flags().BoolVar(&resetConfig, "reset-config", false, "restore the env and labels of the original base, from the base's provenance")
+	cmd.Flags().StringVar(&cloneLabel, "label", images.DefaultCloneLabel, "label marking the clone, as key or key=value; the value defaults to the time")
+	cmd.Flags().StringVar(&cloneMarker, "marker", images.MarkerAuto, "when to set --label: auto (only on r8.im, which refuses identical images), always or never")
 	cmd.MarkFlagRequired("base")
 	cmd.MarkFlagRequired("dest")
 
@@ -27,20 +39,73 @@ func newCloneCommand() *cobra.Command {
 }
 
 func cloneCommmand(cmd *cobra.Command, args []string) error {
-	session := authenticate()
-	if session == nil {
-		fmt.Fprintln(os.Stderr, "authentication error, invalid token or registry host error")
-		return nil
+	ctx := cmd.Context()
+
+	if err := images.CheckMarker(cloneMarker); err != nil {
+		return err
+	}
+
+	session, err := authenticate()
+	if err != nil {
+		return err
+	}
+
+	if err := resolveBase(ctx, &baseRef); err != nil {
+		return err
+	}
+	for i := range cloneDests {
+		if err := ensureRegistry(&cloneDests[i]); err != nil {
+			return err
+		}
+	}
+
+	// pulled once, for every preflight and the clones
+	base, err := imagesConfig(session).Pull(ctx, baseRef)
+	if err != nil {
+		return fmt.Errorf("pulling %w", err)
 	}
 
-	baseRef = images.EnsureRegistry(baseRef)
-	dest = images.EnsureRegistry(dest)
+	if !skipPreflight {
+		for _, dest := range cloneDests {
+			err = images.Preflight(ctx, images.PreflightCheck{
+				Config:  imagesConfig(session),
+				BaseRef: baseRef,
+				Base:    base,
+				Dest:    dest,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
 
-	image_id, err := images.Clone(baseRef, dest, session)
+	ids, err := images.Clone(ctx, images.CloneOptions{
+		Config:      imagesConfig(session),
+		BaseRef:     baseRef,
+		Base:        base,
+		Dests:       cloneDests,
+		StripYolo:   stripYolo,
+		ResetConfig: resetConfig,
+		Label:       cloneLabel,
+		Marker:      cloneMarker,
+	})
 	if err != nil {
 		return err
 	}
-	fmt.Println(image_id)
 
-	return nil
+	text := func() {
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+	}
+	if len(ids) == 1 {
+		return printResult(newImageResult(ids[0], baseRef, cloneDests[0]), text)
+	}
+
+	// several clones share one summary of the transfers
+	r := newImageResult("", baseRef, "")
+	for i, id := range ids {
+		r.Images = append(r.Images, newPushedImage(id, cloneDests[i]))
+	}
+	return printResult(r, text)
 }
@@ -1,9 +1,6 @@
 package cli
 
 import (
-	"fmt"
-	"os"
-
 	"github.com/replicate/yolo/pkg/images"
 	"github.com/spf13/cobra"
 )
@@ -25,14 +22,31 @@ func newFetchCommand() *cobra.Command {
 }
 
 func fetchCommmand(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
 	dest := args[0]
 
-	session := authenticate()
-	if session == nil {
-		fmt.Fprintln(os.Stderr, "authentication error, invalid token or registry host error")
-		return nil
+	session, err := authenticate()
+	if err != nil {
+		return err
+	}
+
+	if err := resolveBase(ctx, &baseRef); err != nil {
+		return err
+	}
+	err = images.Extract(ctx, images.ExtractOptions{
+		Config:  imagesConfig(session),
+		BaseRef: baseRef,
+		Dest:    dest,
+	})
+	if err != nil {
+		return err
 	}
 
-	baseRef = images.EnsureRegistry(baseRef)
-	return images.Extract(baseRef, dest, session)
+	return printResult(struct {
+		Base      string             `json:"base"`
+		Dest      string             `json:"dest"`
+		Durations map[string]float64 `json:"durations"`
+		Warnings  []string           `json:"warnings"`
+	}{baseRef, dest, durations(), warnings()}, func() {})
 }
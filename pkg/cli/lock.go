@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/replicate/yolo/pkg/errdefs"
+	"github.com/replicate/yolo/pkg/lockfile"
+	"github.com/replicate/yolo/pkg/version"
+	"github.com/spf13/cobra"
+)
+
+var (
+	lockPath   string
+	locked     bool
+	updateLock bool
+)
+
+func newLockCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "lock",
+		Short:  "pin the base image of a destination in yolo.lock",
+		Hidden: false,
+		RunE:   lockCommmand,
+		Args:   cobra.ExactArgs(0),
+	}
+
+	cmd.Flags().StringVarP(&sToken, "token", "t", "", "replicate api token")
+	cmd.Flags().StringVarP(&baseRef, "base", "b", "", "base image reference.  examples: owner/model or r8.im/owner/model@sha256:hexdigest")
+	cmd.Flags().StringVarP(&dest, "dest", "d", "", "destination image. examples: owner/model or r8.im/owner/model")
+	cmd.Flags().StringVar(&lockPath, "lockfile", lockfile.Filename, "path to the lockfile")
+	cmd.Flags().BoolVar(&updateLock, "update", false, "replace an existing entry that pins a different base")
+	cmd.MarkFlagRequired("base")
+	cmd.MarkFlagRequired("dest")
+
+	return cmd
+}
+
+func lockCommmand(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	session, err := authenticate()
+	if err != nil {
+		return err
+	}
+
+	givenBase := baseRef
+	if err := resolveBase(ctx, &baseRef); err != nil {
+		return err
+	}
+	if err := ensureRegistry(&dest); err != nil {
+		return err
+	}
+
+	l, err := lockfile.Load(lockPath)
+	if err != nil {
+		return err
+	}
+
+	baseDigest, err := imagesConfig(session).ResolveDigest(ctx, baseRef)
+	if err != nil {
+		return err
+	}
+
+	destRepo, err := cfg.Repository(dest)
+	if err != nil {
+		return err
+	}
+
+	entry, ok := l.Destinations[destRepo]
+	if ok && entry.BaseDigest != baseDigest && !updateLock {
+		return errdefs.Errorf(errdefs.Conflict, "%s already pins %s for %s, pass --update to replace it with %s", lockPath, entry.BaseDigest, destRepo, baseDigest)
+	}
+
+	entry.Base = givenBase
+	entry.BaseDigest = baseDigest
+	entry.YoloVersion = version.GetVersion()
+	l.Destinations[destRepo] = entry
+
+	if err := l.Save(); err != nil {
+		return err
+	}
+	return printResult(struct {
+		Dest       string `json:"dest"`
+		Base       string `json:"base"`
+		BaseDigest string `json:"base_digest"`
+	}{destRepo, givenBase, baseDigest}, func() {
+		fmt.Println(baseDigest)
+	})
+}
+
+// lockBase resolves base to the digest it currently points to and, with
+// --locked, refuses to continue if that differs from what the lockfile pins
+// for destRepo.  It returns the lockfile and the digest reference.
+func lockBase(ctx context.Context, base string, destRepo string, session authn.Keychain) (*lockfile.Lockfile, string, error) {
+	l, err := lockfile.Load(lockPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	baseDigest, err := imagesConfig(session).ResolveDigest(ctx, base)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if locked {
+		if err := l.Check(destRepo, baseDigest); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return l, baseDigest, nil
+}
+
+// recordLock writes the image just pushed to destRepo to its lockfile
+// entry.  Only yolo lock adds entries or moves the base they pin, so
+// destinations that aren't locked are left alone.
+func recordLock(l *lockfile.Lockfile, destRepo string, baseDigest string, schema string, imageId string) error {
+	entry, ok := l.Destinations[destRepo]
+	if !ok {
+		return nil
+	}
+
+	if entry.BaseDigest != baseDigest {
+		cfg.Progress.Warn("pushed %s from %s but %s pins %s, run yolo lock --update to move it", destRepo, baseDigest, lockPath, entry.BaseDigest)
+	}
+	entry.SchemaHash = lockfile.HashSchema(schema)
+	entry.YoloVersion = version.GetVersion()
+	entry.DestDigest = imageId[strings.LastIndex(imageId, "@")+1:]
+	l.Destinations[destRepo] = entry
+
+	return l.Save()
+}
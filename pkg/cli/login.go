@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/replicate/yolo/pkg/auth"
+	"github.com/spf13/cobra"
+)
+
+func newLoginCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "login",
+		Short:  "verify and store a replicate token",
+		Hidden: false,
+		RunE:   loginCommmand,
+		Args:   cobra.ExactArgs(0),
+	}
+
+	cmd.Flags().StringVarP(&sToken, "token", "t", "", "replicate api token, read from stdin if not set")
+
+	return cmd
+}
+
+func newLogoutCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "logout",
+		Short:  "remove the stored replicate token",
+		Hidden: false,
+		RunE:   logoutCommmand,
+		Args:   cobra.ExactArgs(0),
+	}
+
+	return cmd
+}
+
+func loginCommmand(cmd *cobra.Command, args []string) error {
+	token := tokenFromEnv()
+	if token == "" {
+		fmt.Fprintf(os.Stderr, "Paste your token from https://replicate.com/auth/token: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("reading token: %w", err)
+		}
+		token = strings.TrimSpace(line)
+	}
+
+	username, err := auth.Login(logger, auth.ReplicateRegistry, token)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "logged in to", auth.ReplicateRegistry, "as", username)
+	return nil
+}
+
+func logoutCommmand(cmd *cobra.Command, args []string) error {
+	if err := auth.Logout(auth.ReplicateRegistry); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "logged out of", auth.ReplicateRegistry)
+	return nil
+}
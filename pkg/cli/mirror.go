@@ -0,0 +1,268 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/replicate/yolo/pkg/auth"
+	"github.com/replicate/yolo/pkg/errdefs"
+	"github.com/replicate/yolo/pkg/images"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	mirrorFrom        string
+	mirrorTo          string
+	mirrorList        string
+	fromToken         string
+	fromUsername      string
+	fromPasswordStdin bool
+	toToken           string
+	toUsername        string
+	toPasswordStdin   bool
+)
+
+func newMirrorCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "mirror",
+		Short:  "copy images between registries unchanged",
+		Hidden: false,
+		RunE:   mirrorCommmand,
+		Args:   cobra.ExactArgs(0),
+	}
+
+	cmd.Flags().StringVarP(&sToken, "token", "t", "", "replicate api token, for either side without credentials of its own")
+	cmd.Flags().StringVar(&mirrorFrom, "from", "", "image to copy.  examples: owner/model or r8.im/owner/model@sha256:hexdigest")
+	cmd.Flags().StringVar(&mirrorTo, "to", "", "where to copy it.  examples: registry.example.com/team/model")
+	cmd.Flags().StringVar(&mirrorList, "list", "", "file with one \"from to\" pair per line, copied --jobs at a time; # starts a comment")
+	cmd.Flags().StringVar(&fromToken, "from-token", "", "replicate api token for reading from r8.im")
+	cmd.Flags().StringVar(&fromUsername, "from-username", os.Getenv("YOLO_FROM_USERNAME"), "username for the source registry, with the password in $YOLO_FROM_PASSWORD or on stdin")
+	cmd.Flags().BoolVar(&fromPasswordStdin, "from-password-stdin", false, "read the source registry's password from stdin")
+	cmd.Flags().StringVar(&toToken, "to-token", "", "replicate api token for pushing to r8.im")
+	cmd.Flags().StringVar(&toUsername, "to-username", os.Getenv("YOLO_TO_USERNAME"), "username for the destination registry, with the password in $YOLO_TO_PASSWORD or on stdin")
+	cmd.Flags().BoolVar(&toPasswordStdin, "to-password-stdin", false, "read the destination registry's password from stdin")
+	cmd.MarkFlagsRequiredTogether("from", "to")
+	cmd.MarkFlagsMutuallyExclusive("from", "list")
+	cmd.MarkFlagsMutuallyExclusive("to", "list")
+	cmd.MarkFlagsMutuallyExclusive("from-password-stdin", "to-password-stdin")
+
+	return cmd
+}
+
+// mirrorPair is one image to copy
+type mirrorPair struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// mirrorResult is what mirror prints with --output json for a --list
+type mirrorResult struct {
+	Images    []mirroredImage    `json:"images"`
+	Durations map[string]float64 `json:"durations"`
+	Warnings  []string           `json:"warnings"`
+}
+
+type mirroredImage struct {
+	mirrorPair
+	ImageId string `json:"image_id"`
+	Digest  string `json:"digest"`
+}
+
+func mirrorCommmand(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	if mirrorFrom == "" && mirrorList == "" {
+		return errdefs.Errorf(errdefs.InvalidInput, "either --from and --to, or --list is required")
+	}
+
+	pairs := []mirrorPair{{From: mirrorFrom, To: mirrorTo}}
+	if mirrorList != "" {
+		var err error
+		pairs, err = readMirrorList(mirrorList)
+		if err != nil {
+			return err
+		}
+	}
+
+	fromPassword, err := mirrorPassword("YOLO_FROM_PASSWORD", fromPasswordStdin)
+	if err != nil {
+		return err
+	}
+	toPassword, err := mirrorPassword("YOLO_TO_PASSWORD", toPasswordStdin)
+	if err != nil {
+		return err
+	}
+
+	apiToken := fromToken
+	if apiToken == "" {
+		apiToken = sToken
+	}
+	for i := range pairs {
+		pairs[i].From, err = cfg.ResolveBase(ctx, pairs[i].From, apiToken)
+		if err != nil {
+			return err
+		}
+		if err := ensureRegistry(&pairs[i].To); err != nil {
+			return err
+		}
+	}
+
+	// sessions are checked before anything is copied.  Each one only sends
+	// the username and password to the registry of its side.
+	fromSessions := make([]authn.Keychain, len(pairs))
+	toSessions := make([]authn.Keychain, len(pairs))
+	for i, p := range pairs {
+		fromSessions[i], err = mirrorSession(fromToken, fromUsername, fromPassword, p.From)
+		if err != nil {
+			return fmt.Errorf("source credentials: %w", err)
+		}
+		toSessions[i], err = mirrorSession(toToken, toUsername, toPassword, p.To)
+		if err != nil {
+			return fmt.Errorf("destination credentials: %w", err)
+		}
+	}
+
+	copyConfig := cfg
+	mirror := func(ctx context.Context, i int) (string, error) {
+		return images.Copy(ctx, images.CopyOptions{
+			Config:      copyConfig,
+			From:        pairs[i].From,
+			To:          pairs[i].To,
+			FromSession: fromSessions[i],
+			ToSession:   toSessions[i],
+		})
+	}
+
+	if mirrorList == "" {
+		id, err := mirror(ctx, 0)
+		if err != nil {
+			return err
+		}
+		return printResult(newImageResult(id, pairs[0].From, pairs[0].To), func() {
+			fmt.Println(id)
+		})
+	}
+
+	// one failure doesn't stop the rest of the list
+	var (
+		mu     sync.Mutex
+		failed []error
+	)
+	// --jobs bounds the transfers of the whole list: images are copied side
+	// by side, and each gets its share of the jobs for its layers
+	parallel := min(cfg.Jobs, len(pairs))
+	copyConfig.Jobs = max(1, cfg.Jobs/parallel)
+
+	results := make([]mirroredImage, len(pairs))
+	var g errgroup.Group
+	g.SetLimit(parallel)
+	for i, p := range pairs {
+		i, p := i, p
+		g.Go(func() error {
+			id, err := mirror(ctx, i)
+			if err != nil {
+				logger.Error("mirror failed", "from", p.From, "to", p.To, "error", err)
+				mu.Lock()
+				failed = append(failed, fmt.Errorf("%s to %s: %w", p.From, p.To, err))
+				mu.Unlock()
+				return nil
+			}
+			pushed := newPushedImage(id, p.To)
+			results[i] = mirroredImage{mirrorPair: p, ImageId: pushed.ImageId, Digest: pushed.Digest}
+			return nil
+		})
+	}
+	g.Wait()
+
+	if len(failed) > 0 {
+		return errdefs.Wrap(errdefs.KindOf(failed[0]), fmt.Errorf("%d of %d mirrors failed:\n%w", len(failed), len(pairs), errors.Join(failed...)))
+	}
+
+	r := mirrorResult{Images: results, Durations: durations(), Warnings: warnings()}
+	return printResult(r, func() {
+		for _, m := range results {
+			fmt.Println(m.ImageId)
+		}
+	})
+}
+
+// readMirrorList reads "from to" pairs, one per line.  Blank lines and
+// everything after a # are ignored.
+func readMirrorList(path string) ([]mirrorPair, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errdefs.Wrap(errdefs.InvalidInput, err)
+	}
+	defer f.Close()
+
+	var pairs []mirrorPair
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, errdefs.Errorf(errdefs.InvalidInput, "%s:%d: expected \"from to\", got %q", path, n, strings.TrimSpace(line))
+		}
+		pairs = append(pairs, mirrorPair{From: fields[0], To: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, errdefs.Errorf(errdefs.InvalidInput, "%s has no images to mirror", path)
+	}
+	return pairs, nil
+}
+
+// mirrorPassword returns the password from stdin if fromStdin is set, and
+// otherwise from the env variable.  Passwords aren't taken as flags, which
+// would leave them in shell history and process listings.
+func mirrorPassword(env string, fromStdin bool) (string, error) {
+	if !fromStdin {
+		return os.Getenv(env), nil
+	}
+
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("reading password from stdin: %w", err)
+	}
+	password := strings.TrimRight(string(b), "\r\n")
+	if password == "" {
+		return "", errdefs.Errorf(errdefs.InvalidInput, "no password on stdin")
+	}
+	return password, nil
+}
+
+// mirrorSession returns the keychain for one side of a mirror, where ref is
+// that side's image: the username and password for ref's registry, the
+// token for r8.im, or without either the same credentials as every other
+// command.  Other registries, like pull-through mirrors, get the Docker
+// credentials.
+func mirrorSession(token string, username string, password string, ref string) (authn.Keychain, error) {
+	switch {
+	case username != "":
+		r, err := cfg.ParseReference(ref)
+		if err != nil {
+			return nil, err
+		}
+		return auth.NewKeychain(r.Context().RegistryStr(), authn.FromConfig(authn.AuthConfig{Username: username, Password: password})), nil
+	case token != "":
+		u, err := auth.VerifyCogTokenCached(logger, auth.ReplicateRegistry, token)
+		if err != nil {
+			return nil, errdefs.Wrap(errdefs.KindOf(err), fmt.Errorf("authentication error, invalid token or registry host error: %w", err))
+		}
+		return auth.NewKeychain(auth.ReplicateRegistry, authn.FromConfig(authn.AuthConfig{Username: u, Password: token})), nil
+	}
+	return authenticate()
+}
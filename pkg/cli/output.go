@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/replicate/yolo/pkg/errdefs"
+	"github.com/replicate/yolo/pkg/progress"
+)
+
+var outputFormat = "text"
+
+// imageResult is what commands that push an image print with --output json.
+// Commands that push several images list them in Images instead.
+type imageResult struct {
+	ImageId       string              `json:"image_id,omitempty"`
+	Digest        string              `json:"digest,omitempty"`
+	Dest          string              `json:"dest,omitempty"`
+	Images        []pushedImage       `json:"images,omitempty"`
+	Base          string              `json:"base,omitempty"`
+	LayersAdded   int                 `json:"layers_added"`
+	BytesUploaded int64               `json:"bytes_uploaded"`
+	LayersMounted int                 `json:"layers_mounted"`
+	BytesMounted  int64               `json:"bytes_mounted"`
+	Layers        []progress.Transfer `json:"layers"`
+	Durations     map[string]float64  `json:"durations"`
+	Warnings      []string            `json:"warnings"`
+}
+
+// pushedImage is one of several images a command pushed
+type pushedImage struct {
+	ImageId string `json:"image_id"`
+	Digest  string `json:"digest"`
+	Dest    string `json:"dest"`
+}
+
+func newPushedImage(imageId string, dest string) pushedImage {
+	p := pushedImage{ImageId: imageId, Dest: dest}
+	if _, digest, found := strings.Cut(imageId, "@"); found {
+		p.Digest = digest
+	}
+	return p
+}
+
+func newImageResult(imageId string, base string, dest string) imageResult {
+	pushed := newPushedImage(imageId, dest)
+	r := imageResult{
+		ImageId:   pushed.ImageId,
+		Digest:    pushed.Digest,
+		Dest:      pushed.Dest,
+		Base:      base,
+		Layers:    cfg.Progress.Transfers(),
+		Durations: durations(),
+		Warnings:  warnings(),
+	}
+	for _, l := range r.Layers {
+		switch {
+		case l.Mounted:
+			r.LayersMounted++
+			r.BytesMounted += l.Size
+		case !l.Skipped:
+			r.LayersAdded++
+		}
+		r.BytesUploaded += l.Sent
+	}
+	if r.Layers == nil {
+		r.Layers = []progress.Transfer{}
+	}
+	return r
+}
+
+// durations returns the time spent in each phase so far, in seconds
+func durations() map[string]float64 {
+	d := map[string]float64{}
+	for _, t := range cfg.Progress.Timings() {
+		d[t.Name] = t.Duration.Seconds()
+	}
+	return d
+}
+
+// warnings returns the warnings printed so far, never nil so json has []
+func warnings() []string {
+	w := cfg.Progress.Warnings()
+	if w == nil {
+		w = []string{}
+	}
+	return w
+}
+
+func checkOutputFormat() error {
+	switch outputFormat {
+	case "text", "json":
+		return nil
+	}
+	return errdefs.Errorf(errdefs.InvalidInput, "unknown output format %q, expected text or json", outputFormat)
+}
+
+// printResult writes v to stdout as json with --output json, and otherwise
+// calls text to print the human readable form
+func printResult(v any, text func()) error {
+	if outputFormat != "json" {
+		text()
+		return nil
+	}
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
+
+// PrintError reports an error from a command, as json on stdout with
+// --output json and always as text on stderr
+func PrintError(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	if outputFormat != "json" {
+		return
+	}
+	json.NewEncoder(os.Stdout).Encode(struct {
+		Error *errdefs.Error `json:"error"`
+	}{errdefs.AsError(err)})
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/replicate/yolo/pkg/auth"
+	"github.com/replicate/yolo/pkg/config"
+	"github.com/replicate/yolo/pkg/errdefs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	profileName   string
+	activeProfile string
+)
+
+// applyProfile fills in defaults from the selected profile.  Flags and
+// YOLO_* environment variables still win over the profile.
+func applyProfile(cmd *cobra.Command, args []string) error {
+	path, err := config.Path()
+	if err != nil {
+		return err
+	}
+
+	file, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+
+	selected, p, err := file.Profile(profileName)
+	if err != nil {
+		return err
+	}
+	if selected == "" {
+		return nil
+	}
+	activeProfile = selected
+
+	flags := cmd.Flags()
+	if p.Registry != "" && !flags.Changed("registry") && os.Getenv("YOLO_REGISTRY") == "" {
+		cfg.DefaultRegistry = p.Registry
+	}
+	if p.TestAPI != "" && flags.Lookup("test-api") != nil && !flags.Changed("test-api") {
+		sBaseApi = p.TestAPI
+	}
+	cfg.DefaultOwner = p.Owner
+
+	if !flags.Changed("token") {
+		token, err := p.ResolveToken()
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", selected, err)
+		}
+		if token != "" {
+			sToken = token
+		}
+	}
+
+	return nil
+}
+
+func newWhoamiCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "whoami",
+		Short:  "show the active profile and replicate user",
+		Hidden: false,
+		RunE:   whoamiCommmand,
+		Args:   cobra.ExactArgs(0),
+	}
+
+	cmd.Flags().StringVarP(&sToken, "token", "t", "", "replicate api token")
+
+	return cmd
+}
+
+func whoamiCommmand(cmd *cobra.Command, args []string) error {
+	token := tokenFromEnv()
+	if token == "" {
+		token = storedToken(auth.ReplicateRegistry)
+	}
+	if token == "" {
+		return errdefs.Errorf(errdefs.Auth, "not logged in, run yolo login or set REPLICATE_API_TOKEN")
+	}
+
+	username, err := auth.VerifyCogToken(logger, auth.ReplicateRegistry, token)
+	if err != nil {
+		return err
+	}
+
+	return printResult(struct {
+		Profile  string `json:"profile"`
+		Registry string `json:"registry"`
+		Username string `json:"username"`
+	}{activeProfile, cfg.DefaultRegistry, username}, func() {
+		if activeProfile != "" {
+			fmt.Println("profile: ", activeProfile)
+		} else {
+			fmt.Println("profile:  (none)")
+		}
+		fmt.Println("registry:", cfg.DefaultRegistry)
+		fmt.Println("username:", username)
+	})
+}
+
+// storedToken returns the password stored for registry by yolo login
+func storedToken(registry string) string {
+	res, err := name.NewRegistry(registry)
+	if err != nil {
+		return ""
+	}
+	a, err := authn.DefaultKeychain.Resolve(res)
+	if err != nil {
+		return ""
+	}
+	c, err := a.Authorization()
+	if err != nil {
+		return ""
+	}
+	return c.Password
+}
@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/replicate/yolo/pkg/images"
+	"github.com/spf13/cobra"
+)
+
+func newProvenanceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "provenance",
+		Short:  "show where a yolo image came from",
+		Hidden: false,
+		RunE:   provenanceCommmand,
+		Args:   cobra.ExactArgs(0),
+	}
+
+	cmd.Flags().StringVarP(&sToken, "token", "t", "", "replicate api token")
+	cmd.Flags().StringVarP(&baseRef, "base", "b", "", "image reference.  examples: owner/model or r8.im/owner/model@sha256:hexdigest")
+	cmd.MarkFlagRequired("base")
+
+	return cmd
+}
+
+func provenanceCommmand(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	session, err := authenticate()
+	if err != nil {
+		return err
+	}
+
+	if err := resolveBase(ctx, &baseRef); err != nil {
+		return err
+	}
+
+	p, err := images.ReadProvenance(ctx, images.ProvenanceOptions{
+		Config: imagesConfig(session),
+		Ref:    baseRef,
+	})
+	if err != nil {
+		return err
+	}
+
+	return printResult(p, func() {
+		fmt.Println("base:   ", p.Base)
+		fmt.Println("version:", p.Version)
+		if p.Commit != "" {
+			fmt.Println("commit: ", p.Commit)
+		}
+		fmt.Println("created:", p.Created)
+		if len(p.Manifest) > 0 {
+			fmt.Println("files:")
+			for _, f := range p.Files() {
+				fmt.Printf("  %s  %s\n", p.Manifest[f], f)
+			}
+		}
+	})
+}
@@ -6,8 +6,12 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/dustin/go-humanize"
+
 	"github.com/replicate/yolo/pkg/auth"
+	"github.com/replicate/yolo/pkg/errdefs"
 	"github.com/replicate/yolo/pkg/images"
+	"github.com/replicate/yolo/pkg/lockfile"
 	"github.com/spf13/cobra"
 )
 
@@ -21,6 +25,10 @@ var (
 	openapi       string
 	sampleDir     string
 	relativePaths bool
+	stack         bool
+	skipPreflight bool
+	ifMatch       string
+	maxSize       = byteSize(5 * humanize.GByte)
 	env           []string
 )
 
@@ -44,25 +52,87 @@ func newPushCommand() *cobra.Command {
 	cmd.Flags().StringVarP(&commit, "commit", "c", "", "optional commit hash to update git commit")
 	cmd.Flags().StringVarP(&sampleDir, "sample-dir", "s", "", "optional directory to run samples")
 	cmd.Flags().StringVarP(&sBaseApi, "test-api", "u", "http://localhost:4000", "experiment endpoint")
+	cmd.Flags().BoolVar(&stack, "stack", false, "keep prior yolo layers and only add the new files as another layer")
+	cmd.Flags().StringVar(&lockPath, "lockfile", lockfile.Filename, "path to the lockfile")
+	cmd.Flags().BoolVar(&locked, "locked", false, "refuse to push if the base resolves to a different digest than the lockfile")
+	cmd.Flags().StringVar(&ifMatch, "if-match", "", "only push if the destination is still at this digest")
+	cmd.Flags().BoolVar(&skipPreflight, "skip-preflight", false, "don't check the destination, base, files and schema before pushing")
+	cmd.Flags().Var(&maxSize, "max-size", "largest total size of files to push")
 	cmd.Flags().StringArrayVarP(&env, "env", "e", []string{}, "environment variables to add to the image")
 	return cmd
 }
 
 func pushCommmand(cmd *cobra.Command, args []string) error {
-	session := authenticate()
-	if session == nil {
-		fmt.Fprintln(os.Stderr, "authentication error, invalid token or registry host error")
-		return nil
+	ctx := cmd.Context()
+
+	session, err := authenticate()
+	if err != nil {
+		return err
+	}
+
+	if err := resolveBase(ctx, &baseRef); err != nil {
+		return err
+	}
+	if err := ensureRegistry(&dest); err != nil {
+		return err
+	}
+
+	destRepo, err := cfg.Repository(dest)
+	if err != nil {
+		return err
+	}
+	lock, baseDigest, err := lockBase(ctx, baseRef, destRepo, session)
+	if err != nil {
+		return err
+	}
+	if locked && ifMatch == "" {
+		// the destination must still be at the last locked push
+		ifMatch = lock.Destinations[destRepo].DestDigest
 	}
 
-	baseRef = images.EnsureRegistry(baseRef)
-	dest = images.EnsureRegistry(dest)
+	var schema string
+
+	if openapi != "" {
+		schemaBytes, err := os.ReadFile(openapi)
+		if err != nil {
+			return errdefs.Wrap(errdefs.InvalidInput, fmt.Errorf("reading openapi file: %w", err))
+		}
+		schema = string(schemaBytes)
+	}
+
+	if ast != "" {
+		schema, err = images.GetSchema(ast)
+		if err != nil {
+			return fmt.Errorf("parsing schema: %w", err)
+		}
+	}
+
+	// pulled once, for the preflight and the push
+	base, err := imagesConfig(session).Pull(ctx, baseDigest)
+	if err != nil {
+		return fmt.Errorf("pulling %w", err)
+	}
+
+	if !skipPreflight {
+		err = images.Preflight(ctx, images.PreflightCheck{
+			Config:  imagesConfig(session),
+			BaseRef: baseDigest,
+			Base:    base,
+			Dest:    dest,
+			Files:   args,
+			MaxSize: int64(maxSize),
+			Schema:  schema,
+		})
+		if err != nil {
+			return err
+		}
+	}
 
 	var files []images.LayerFile
 	for _, path := range args {
 		body, err := os.ReadFile(path)
 		if err != nil {
-			return err
+			return errdefs.Wrap(errdefs.InvalidInput, err)
 		}
 
 		var dest string
@@ -84,38 +154,38 @@ func pushCommmand(cmd *cobra.Command, args []string) error {
 		files = append(files, file)
 	}
 
-	var schema string
-	var err error
-
-	if openapi != "" {
-		schemaBytes, err := os.ReadFile(openapi)
-		if err != nil {
-			return fmt.Errorf("reading openapi file: %w", err)
-		}
-		schema = string(schemaBytes)
-	}
-
-	if ast != "" {
-		schema, err = images.GetSchema(ast)
-		if err != nil {
-			return fmt.Errorf("parsing schema: %w", err)
-		}
-	}
-
-	image_id, err := images.Yolo(baseRef, dest, files, schema, commit, env, session)
+	result, err := images.Yolo(ctx, images.YoloOptions{
+		Config:  imagesConfig(session),
+		BaseRef: baseDigest,
+		Base:    base,
+		Dest:    dest,
+		Files:   files,
+		Schema:  schema,
+		Commit:  commit,
+		Env:     env,
+		Stack:   stack,
+		IfMatch: ifMatch,
+	})
 	if err != nil {
 		return err
 	}
+	image_id := result.ImageId
 
-	fmt.Println(image_id)
+	if err := recordLock(lock, destRepo, baseDigest, schema, image_id); err != nil {
+		return fmt.Errorf("updating %s: %w", lockPath, err)
+	}
 
+	// samples run before the result is printed, so json output is a single
+	// object either way
 	if sampleDir != "" {
-		fmt.Println("running samples")
-		err = auth.MakeSamples(image_id, sampleDir, sToken, sBaseApi)
+		logger.Info("running samples", "dir", sampleDir)
+		err = auth.MakeSamples(logger, image_id, sampleDir, sToken, sBaseApi)
 		if err != nil {
-			return err
+			return fmt.Errorf("pushed %s, but running samples failed: %w", image_id, err)
 		}
 	}
 
-	return nil
+	return printResult(newImageResult(image_id, baseDigest, dest), func() {
+		fmt.Println(image_id)
+	})
 }
@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/replicate/yolo/pkg/images"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fromRef string
+	ontoRef string
+	force   bool
+)
+
+func newRebaseCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "rebase",
+		Short:  "move yolo changes onto a newer base image",
+		Hidden: false,
+		RunE:   rebaseCommmand,
+		Args:   cobra.ExactArgs(0),
+	}
+
+	cmd.Flags().StringVarP(&sToken, "token", "t", "", "replicate api token")
+	cmd.Flags().StringVarP(&fromRef, "from", "f", "", "yolo image whose changes to move.  examples: owner/model or r8.im/owner/model@sha256:hexdigest")
+	cmd.Flags().StringVarP(&ontoRef, "onto", "b", "", "new base image reference.  examples: owner/model or r8.im/owner/model@sha256:hexdigest")
+	cmd.Flags().StringVarP(&dest, "dest", "d", "", "destination image. examples: owner/model or r8.im/owner/model")
+	cmd.Flags().BoolVar(&force, "force", false, "rebase even if the new base changed the same files as yolo")
+	cmd.MarkFlagRequired("from")
+	cmd.MarkFlagRequired("onto")
+	cmd.MarkFlagRequired("dest")
+
+	return cmd
+}
+
+func rebaseCommmand(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	session, err := authenticate()
+	if err != nil {
+		return err
+	}
+
+	if err := resolveBase(ctx, &fromRef, &ontoRef); err != nil {
+		return err
+	}
+	if err := ensureRegistry(&dest); err != nil {
+		return err
+	}
+
+	image_id, err := images.Rebase(ctx, images.RebaseOptions{
+		Config:  imagesConfig(session),
+		FromRef: fromRef,
+		OntoRef: ontoRef,
+		Dest:    dest,
+		Force:   force,
+	})
+	if err != nil {
+		return err
+	}
+	return printResult(newImageResult(image_id, ontoRef, dest), func() {
+		fmt.Println(image_id)
+	})
+}
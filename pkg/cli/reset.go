@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/replicate/yolo/pkg/images"
+	"github.com/spf13/cobra"
+)
+
+func newResetCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "reset",
+		Short:  "strip all yolo changes from an image",
+		Hidden: false,
+		RunE:   resetCommmand,
+		Args:   cobra.ExactArgs(0),
+	}
+
+	cmd.Flags().StringVarP(&sToken, "token", "t", "", "replicate api token")
+	cmd.Flags().StringVarP(&baseRef, "base", "b", "", "yolo image to reset.  examples: owner/model or r8.im/owner/model@sha256:hexdigest")
+	cmd.Flags().StringVarP(&dest, "dest", "d", "", "destination image. examples: owner/model or r8.im/owner/model")
+	cmd.MarkFlagRequired("base")
+	cmd.MarkFlagRequired("dest")
+
+	return cmd
+}
+
+func resetCommmand(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	session, err := authenticate()
+	if err != nil {
+		return err
+	}
+
+	if err := resolveBase(ctx, &baseRef); err != nil {
+		return err
+	}
+	if err := ensureRegistry(&dest); err != nil {
+		return err
+	}
+
+	image_id, err := images.Reset(ctx, images.ResetOptions{
+		Config:  imagesConfig(session),
+		BaseRef: baseRef,
+		Dest:    dest,
+	})
+	if err != nil {
+		return err
+	}
+	return printResult(newImageResult(image_id, baseRef, dest), func() {
+		fmt.Println(image_id)
+	})
+}
@@ -1,13 +1,20 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 
+	"github.com/dustin/go-humanize"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/logs"
 	"github.com/replicate/yolo/pkg/auth"
+	"github.com/replicate/yolo/pkg/errdefs"
+	"github.com/replicate/yolo/pkg/images"
+	"github.com/replicate/yolo/pkg/logging"
+	"github.com/replicate/yolo/pkg/progress"
 	"github.com/replicate/yolo/pkg/version"
 	"github.com/spf13/cobra"
 )
@@ -18,20 +25,156 @@ func NewRootCommand() (*cobra.Command, error) {
 		Short:         "remix the web",
 		Version:       version.GetVersion(),
 		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkOutputFormat(); err != nil {
+				return err
+			}
+			if err := setupLogging(); err != nil {
+				return err
+			}
+			if err := setupMirrors(); err != nil {
+				return err
+			}
+			if cfg.Jobs < 1 {
+				return errdefs.Errorf(errdefs.InvalidInput, "--jobs must be at least 1, got %d", cfg.Jobs)
+			}
+			setupCache()
+			// flags are fine, so later errors aren't about usage
+			cmd.SilenceUsage = true
+			return applyProfile(cmd, args)
+		},
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			cfg.Progress.Summary()
+		},
 	}
 
+	rootCmd.PersistentFlags().StringVar(&cfg.DefaultRegistry, "registry", envOr("YOLO_REGISTRY", images.DefaultRegistry), "registry used for references without a host")
+	rootCmd.PersistentFlags().StringVar(&cfg.APIBaseURL, "api-url", envOr("YOLO_API_URL", images.DefaultAPIBaseURL), "replicate api used to resolve owner/model to the latest version")
+	rootCmd.PersistentFlags().StringArrayVar(&mirrors, "registry-mirror", nil, "pull-through mirror tried before the registry, as [registry=]host[/prefix]; the registry defaults to --registry")
+	rootCmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", os.Getenv("YOLO_CACHE_DIR"), "where pulled manifests, configs and layers are cached, defaults to ~/.cache/yolo")
+	rootCmd.PersistentFlags().Var(&cacheSize, "cache-size", "largest size of the cache, least recently used entries are removed past it")
+	rootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "don't read or write the cache")
+	rootCmd.PersistentFlags().IntVar(&cfg.Jobs, "jobs", images.DefaultJobs, "how many layers to upload or download at once")
+	rootCmd.PersistentFlags().IntVar(&cfg.Retries, "retries", images.DefaultRetries, "how many times to retry failed pulls and pushes")
+	rootCmd.PersistentFlags().DurationVar(&cfg.RetryBackoff, "retry-backoff", images.DefaultRetryBackoff, "delay before the first retry, doubled after each attempt")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", outputFormat, "output format, text or json.  json prints a single result to stdout and logs to stderr")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "only print warnings and errors")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "print every file added, skipped and extracted")
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "also trace every http request, with credentials redacted")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", os.Getenv("YOLO_PROFILE"), "profile from the yolo config file to use")
+
 	rootCmd.AddCommand(
+		newCacheCommand(),
 		newCloneCommand(),
 		newFetchCommand(),
+		newLockCommand(),
+		newLoginCommand(),
+		newLogoutCommand(),
+		newMirrorCommand(),
+		newProvenanceCommand(),
 		newPushCommand(),
+		newRebaseCommand(),
+		newResetCommand(),
+		newSquashCommand(),
+		newWhoamiCommand(),
 	)
 	logs.Warn = log.New(os.Stderr, "gcr WARN: ", log.LstdFlags)
-	logs.Progress = log.New(os.Stderr, "gcr: ", log.LstdFlags)
+	cfg.Progress = progress.New(os.Stderr)
 
 	return &rootCmd, nil
 }
 
-func authenticate() authn.Authenticator {
+var (
+	quiet   bool
+	verbose bool
+	debug   bool
+
+	logger = logging.New(os.Stderr, slog.LevelInfo)
+
+	// cfg is what every command runs images operations with, filled in from
+	// the global flags and the profile
+	cfg images.Config
+)
+
+// imagesConfig returns cfg with the session a command authenticated with
+func imagesConfig(session authn.Keychain) images.Config {
+	c := cfg
+	c.Session = session
+	return c
+}
+
+// setupLogging points the images and go-containerregistry loggers at stderr
+// with the level picked by --quiet, --verbose or --debug
+func setupLogging() error {
+	level := slog.LevelInfo
+	switch {
+	case quiet && (verbose || debug):
+		return errdefs.Errorf(errdefs.InvalidInput, "--quiet can't be combined with --verbose or --debug")
+	case quiet:
+		level = slog.LevelWarn
+	case debug:
+		level = logging.LevelTrace
+	case verbose:
+		level = slog.LevelDebug
+	}
+
+	logger = logging.New(os.Stderr, level)
+	cfg.Log = logger
+	// go-containerregistry warns about fallbacks it recovers from, like a
+	// HEAD it retries as a GET; those are only interesting when debugging
+	logs.Warn = slog.NewLogLogger(logger.Handler(), slog.LevelDebug)
+	cfg.Progress.Quiet = quiet
+	return nil
+}
+
+var mirrors []string
+
+func setupMirrors() error {
+	cfg.Mirrors = nil
+	for _, s := range mirrors {
+		m, err := images.ParseMirror(s)
+		if err != nil {
+			return err
+		}
+		cfg.Mirrors = append(cfg.Mirrors, m)
+	}
+	return nil
+}
+
+func envOr(key string, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// ensureRegistry fully qualifies each image reference in place
+func ensureRegistry(refs ...*string) error {
+	for _, ref := range refs {
+		r, err := cfg.EnsureRegistry(*ref)
+		if err != nil {
+			return err
+		}
+		*ref = r
+	}
+	return nil
+}
+
+// resolveBase fully qualifies each source image reference in place,
+// resolving owner/model to the latest version
+func resolveBase(ctx context.Context, refs ...*string) error {
+	for _, ref := range refs {
+		r, err := cfg.ResolveBase(ctx, *ref, sToken)
+		if err != nil {
+			return err
+		}
+		*ref = r
+	}
+	return nil
+}
+
+// tokenFromEnv fills sToken from the environment if --token wasn't given
+func tokenFromEnv() string {
 	if sToken == "" {
 		sToken = os.Getenv("REPLICATE_API_TOKEN")
 	}
@@ -40,14 +183,42 @@ func authenticate() authn.Authenticator {
 		sToken = os.Getenv("COG_TOKEN")
 	}
 
-	if sToken != "" {
-		u, err := auth.VerifyCogToken(sToken)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "authentication error, invalid token or registry host error")
-			return nil
-		}
-		return authn.FromConfig(authn.AuthConfig{Username: u, Password: sToken})
+	return sToken
+}
+
+// authenticate returns a keychain that uses the replicate token for r8.im
+// and the Docker keychain for every other registry.  Without a token, the
+// credentials stored by yolo login are used, and their token is kept in
+// sToken for the models and samples apis.
+func authenticate() (authn.Keychain, error) {
+	if tokenFromEnv() == "" {
+		sToken = storedToken(auth.ReplicateRegistry)
+		return authn.DefaultKeychain, nil
+	}
+
+	u, err := auth.VerifyCogTokenCached(logger, auth.ReplicateRegistry, sToken)
+	if err != nil {
+		return nil, errdefs.Wrap(errdefs.KindOf(err), fmt.Errorf("authentication error, invalid token or registry host error: %w", err))
+	}
+	return auth.NewKeychain(auth.ReplicateRegistry, authn.FromConfig(authn.AuthConfig{Username: u, Password: sToken})), nil
+}
+
+// byteSize is a flag value that accepts human readable sizes like 500MB
+type byteSize uint64
+
+func (b *byteSize) String() string {
+	return humanize.Bytes(uint64(*b))
+}
+
+func (b *byteSize) Set(s string) error {
+	v, err := humanize.ParseBytes(s)
+	if err != nil {
+		return err
 	}
+	*b = byteSize(v)
+	return nil
+}
 
-	return authn.Anonymous
+func (b *byteSize) Type() string {
+	return "size"
 }
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/replicate/yolo/pkg/images"
+	"github.com/spf13/cobra"
+)
+
+func newSquashCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "squash",
+		Short:  "collapse stacked yolo layers into one",
+		Hidden: false,
+		RunE:   squashCommmand,
+		Args:   cobra.ExactArgs(0),
+	}
+
+	cmd.Flags().StringVarP(&sToken, "token", "t", "", "replicate api token")
+	cmd.Flags().StringVarP(&baseRef, "base", "b", "", "yolo image to squash.  examples: owner/model or r8.im/owner/model@sha256:hexdigest")
+	cmd.Flags().StringVarP(&dest, "dest", "d", "", "destination image. examples: owner/model or r8.im/owner/model")
+	cmd.MarkFlagRequired("base")
+	cmd.MarkFlagRequired("dest")
+
+	return cmd
+}
+
+func squashCommmand(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	session, err := authenticate()
+	if err != nil {
+		return err
+	}
+
+	if err := resolveBase(ctx, &baseRef); err != nil {
+		return err
+	}
+	if err := ensureRegistry(&dest); err != nil {
+		return err
+	}
+
+	image_id, err := images.Squash(ctx, images.SquashOptions{
+		Config:  imagesConfig(session),
+		BaseRef: baseRef,
+		Dest:    dest,
+	})
+	if err != nil {
+		return err
+	}
+	return printResult(newImageResult(image_id, baseRef, dest), func() {
+		fmt.Println(image_id)
+	})
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/replicate/yolo/pkg/errdefs"
+)
+
+// Profile is a named set of defaults, e.g. a personal and an org account
+type Profile struct {
+	// Token is the replicate token itself; prefer TokenEnv
+	Token string `json:"token,omitempty"`
+	// TokenEnv names the environment variable holding the token
+	TokenEnv string `json:"token_env,omitempty"`
+	Registry string `json:"registry,omitempty"`
+	// Owner is used for references without one, e.g. --dest model
+	Owner   string `json:"owner,omitempty"`
+	TestAPI string `json:"test_api,omitempty"`
+}
+
+type Config struct {
+	DefaultProfile string             `json:"default_profile,omitempty"`
+	Profiles       map[string]Profile `json:"profiles"`
+}
+
+// Path returns $YOLO_CONFIG or config.json in the user config dir
+func Path() (string, error) {
+	if p := os.Getenv("YOLO_CONFIG"); p != "" {
+		return p, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "yolo", "config.json"), nil
+}
+
+// Load reads the config file at path, returning an empty config if it
+// doesn't exist
+func Load(path string) (*Config, error) {
+	cfg := &Config{Profiles: make(map[string]Profile)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = make(map[string]Profile)
+	}
+
+	return cfg, nil
+}
+
+// Profile returns the named profile, or the default profile if name is
+// empty.  It returns an empty name if there's no profile to use.
+func (c *Config) Profile(name string) (string, Profile, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		return "", Profile{}, nil
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return "", Profile{}, errdefs.Errorf(errdefs.InvalidInput, "unknown profile %q, available: %v", name, c.names())
+	}
+	return name, p, nil
+}
+
+func (c *Config) names() []string {
+	var names []string
+	for n := range c.Profiles {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResolveToken returns the token from the profile's token source
+func (p Profile) ResolveToken() (string, error) {
+	if p.TokenEnv != "" {
+		token := os.Getenv(p.TokenEnv)
+		if token == "" {
+			return "", errdefs.Errorf(errdefs.Auth, "%s is not set", p.TokenEnv)
+		}
+		return token, nil
+	}
+	return p.Token, nil
+}
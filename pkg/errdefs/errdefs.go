@@ -0,0 +1,180 @@
+package errdefs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// Kind classifies an error so the CLI can pick an exit code
+type Kind int
+
+const (
+	Unknown Kind = iota
+	InvalidInput
+	Auth
+	PermissionDenied
+	NotFound
+	Retryable
+	Conflict
+	Canceled
+)
+
+var kindNames = map[Kind]string{
+	Unknown:          "unknown",
+	InvalidInput:     "invalid_input",
+	Auth:             "auth",
+	PermissionDenied: "permission_denied",
+	NotFound:         "not_found",
+	Retryable:        "retryable",
+	Conflict:         "conflict",
+	Canceled:         "canceled",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// ExitCode is the process exit code for errors of this kind
+func (k Kind) ExitCode() int {
+	switch k {
+	case InvalidInput:
+		return 2
+	case Auth:
+		return 3
+	case PermissionDenied:
+		return 4
+	case NotFound:
+		return 5
+	case Retryable:
+		return 6
+	case Conflict:
+		return 7
+	case Canceled:
+		// like a shell reporting SIGINT
+		return 130
+	}
+	return 1
+}
+
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind     Kind   `json:"kind"`
+		ExitCode int    `json:"exit_code"`
+		Message  string `json:"message"`
+	}{e.Kind, e.Kind.ExitCode(), e.Error()})
+}
+
+// Wrap marks err as being of the given kind
+func Wrap(kind Kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+func Errorf(kind Kind, format string, a ...any) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, a...)}
+}
+
+// KindOf returns the kind of the first classified error in err's chain,
+// falling back to registry and network errors
+func KindOf(err error) Kind {
+	if err == nil {
+		return Unknown
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return Canceled
+	}
+
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		return kindOfStatus(terr.StatusCode)
+	}
+
+	// a host that doesn't resolve is most likely a typo
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return InvalidInput
+	}
+
+	// a connection dropped in the middle of a response, or one that timed
+	// out, was reset or refused.  Other network errors, like a certificate
+	// that doesn't verify, fail the same way when retried.
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return Retryable
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return Retryable
+	}
+
+	return Unknown
+}
+
+func kindOfStatus(status int) Kind {
+	switch {
+	case status == http.StatusUnauthorized:
+		return Auth
+	case status == http.StatusForbidden:
+		return PermissionDenied
+	case status == http.StatusNotFound:
+		return NotFound
+	case status == http.StatusConflict:
+		return Conflict
+	case status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= 500:
+		return Retryable
+	case status >= 400:
+		return InvalidInput
+	}
+	return Unknown
+}
+
+// FromStatus wraps err with the kind matching an HTTP status code
+func FromStatus(status int, err error) error {
+	return Wrap(kindOfStatus(status), err)
+}
+
+func ExitCode(err error) int {
+	return KindOf(err).ExitCode()
+}
+
+// AsError returns err as an *Error, classifying it if needed
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return &Error{Kind: e.Kind, Err: err}
+	}
+	return &Error{Kind: KindOf(err), Err: err}
+}
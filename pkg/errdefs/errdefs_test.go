@@ -0,0 +1,58 @@
+package errdefs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// urlError is how net/http reports a failed request
+func urlError(err error) error {
+	return &url.Error{Op: "Get", URL: "https://registry.example.com/v2/", Err: err}
+}
+
+func dialError(err error) error {
+	return urlError(&net.OpError{Op: "dial", Net: "tcp", Err: err})
+}
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Kind
+	}{
+		{"nil", nil, Unknown},
+		{"wrapped", fmt.Errorf("pushing: %w", Errorf(Conflict, "moved")), Conflict},
+		{"canceled", fmt.Errorf("pulling: %w", context.Canceled), Canceled},
+		{"deadline", urlError(context.DeadlineExceeded), Retryable},
+		{"not found", &transport.Error{StatusCode: http.StatusNotFound}, NotFound},
+		{"unavailable", &transport.Error{StatusCode: http.StatusServiceUnavailable}, Retryable},
+		{"unexpected eof", urlError(io.ErrUnexpectedEOF), Retryable},
+		{"connection reset", urlError(&net.OpError{Op: "read", Net: "tcp", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}}), Retryable},
+		{"connection refused", dialError(&os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}), Retryable},
+		{"dns timeout", dialError(&net.DNSError{Err: "i/o timeout", Name: "registry.example.com", IsTimeout: true}), Retryable},
+		{"no such host", dialError(&net.DNSError{Err: "no such host", Name: "regsitry.example.com", IsNotFound: true}), InvalidInput},
+		{"unknown authority", urlError(&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}), Unknown},
+		{"wrong host", urlError(x509.HostnameError{Host: "registry.example.com", Certificate: &x509.Certificate{}}), Unknown},
+		{"unsupported scheme", urlError(errors.New(`unsupported protocol scheme "ftp"`)), Unknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := KindOf(tt.err); got != tt.want {
+				t.Errorf("KindOf(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
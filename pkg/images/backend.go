@@ -0,0 +1,133 @@
+package images
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/replicate/yolo/pkg/errdefs"
+)
+
+// Backend fetches and stores images.  Every images operation goes through
+// one, so yolo can work against a registry, an OCI layout, a tarball or
+// memory alike.
+type Backend interface {
+	// Image returns the image ref points to
+	Image(ctx context.Context, ref name.Reference) (v1.Image, error)
+	// Digest returns the digest ref points to, or an errdefs.NotFound error
+	Digest(ctx context.Context, ref name.Reference) (v1.Hash, error)
+	// Write stores img at ref
+	Write(ctx context.Context, ref name.Reference, img v1.Image) error
+}
+
+// backendFrom returns the config's backend, or the registry authenticated
+// with session, through the config's cache if it has one
+func backendFrom(ctx context.Context, session authn.Keychain) Backend {
+	c := configFrom(ctx)
+	if c.Backend != nil {
+		return c.Backend
+	}
+
+	var b Backend = &Registry{Session: session, Mirrors: c.Mirrors}
+	if c.Cache != nil {
+		b = Cached(b, c.Cache)
+	}
+	return b
+}
+
+// pull fetches an image through the config's backend
+func pull(ctx context.Context, ref string, session authn.Keychain) (v1.Image, error) {
+	defer progressFrom(ctx).Phase("pull metadata")()
+
+	r, err := configFrom(ctx).ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	return backendFrom(ctx, session).Image(ctx, r)
+}
+
+// Pull fetches the image ref points to, so that a preflight and the push or
+// clone after it share one pull
+func (c Config) Pull(ctx context.Context, ref string) (v1.Image, error) {
+	ctx = withConfig(ctx, c)
+	logFrom(ctx).Info("fetching metadata", "ref", ref)
+	return pull(ctx, ref, c.Session)
+}
+
+// pullBase is pull for an image whose layers are read to build on it, like
+// the /src layers of a push's base
+func pullBase(ctx context.Context, ref string, session authn.Keychain) (v1.Image, error) {
+	img, err := pull(ctx, ref, session)
+	if err != nil {
+		return nil, err
+	}
+	return cacheLayers(ctx, session, img), nil
+}
+
+// cacheLayers keeps the layers of img in the cache as they are read, if the
+// backend has one.  The next build on the same base reads them again.
+func cacheLayers(ctx context.Context, session authn.Keychain, img v1.Image) v1.Image {
+	if cb, ok := backendFrom(ctx, session).(*cachedBackend); ok {
+		return cb.cacheLayers(img)
+	}
+	return img
+}
+
+// push stores an image through the config's backend.  Layers of the from
+// images are mounted from their repositories when the backend can.
+func push(ctx context.Context, img v1.Image, dest string, session authn.Keychain, from ...mountSource) error {
+	ref, err := configFrom(ctx).ParseReference(dest)
+	if err != nil {
+		return err
+	}
+	ctx, err = withMountFrom(ctx, from...)
+	if err != nil {
+		return err
+	}
+	return backendFrom(ctx, session).Write(ctx, ref, img)
+}
+
+// Memory keeps images in memory, for tests and dry runs
+type Memory struct {
+	mu     sync.Mutex
+	images map[string]v1.Image
+}
+
+func NewMemory() *Memory {
+	return &Memory{images: make(map[string]v1.Image)}
+}
+
+func (m *Memory) Image(_ context.Context, ref name.Reference) (v1.Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	img, ok := m.images[ref.Name()]
+	if !ok {
+		return nil, errdefs.Errorf(errdefs.NotFound, "%s not found", ref.Name())
+	}
+	return img, nil
+}
+
+func (m *Memory) Digest(ctx context.Context, ref name.Reference) (v1.Hash, error) {
+	img, err := m.Image(ctx, ref)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	return img.Digest()
+}
+
+func (m *Memory) Write(_ context.Context, ref name.Reference, img v1.Image) error {
+	d, err := img.Digest()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.images[ref.Name()] = img
+	m.images[ref.Context().Digest(d.String()).Name()] = img
+	return nil
+}
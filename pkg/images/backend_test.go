@@ -0,0 +1,78 @@
+package images
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// TestBackendRoundTrip pushes files onto a base in each backend and fetches
+// them back
+func TestBackendRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	backends := map[string]func(dir string) Backend{
+		"layout":  func(dir string) Backend { return &Layout{Path: filepath.Join(dir, "layout")} },
+		"tarball": func(dir string) Backend { return &Tarball{Path: filepath.Join(dir, "images.tar")} },
+		"memory":  func(string) Backend { return NewMemory() },
+	}
+
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			backend := newBackend(dir)
+			c := testConfig()
+			c.Backend = backend
+
+			base := newCogBase(t, 1024,
+				testFile("src/predict.py", []byte("print('hello')\n")),
+				testFile("src/cog.yaml", []byte("predict: predict.py\n")),
+			)
+			if err := backend.Write(ctx, parseRef(t, "r8.im/acme/base:v1"), base); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := Yolo(ctx, YoloOptions{
+				Config:  c,
+				BaseRef: "r8.im/acme/base:v1",
+				Dest:    "r8.im/acme/model:v1",
+				Files:   []LayerFile{testFile("src/predict.py", []byte("print('hello, world')\n"))},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := backend.Digest(ctx, parseRef(t, "r8.im/acme/model:v1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != result.Digest {
+				t.Errorf("model:v1 is at %s, want %s", got, result.Digest)
+			}
+
+			dest := filepath.Join(dir, "src")
+			err = Extract(ctx, ExtractOptions{
+				Config:  c,
+				BaseRef: result.ImageId,
+				Dest:    dest,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files := readTree(t, dest)
+			want := map[string]string{
+				"src/predict.py": "print('hello, world')\n",
+				"src/cog.yaml":   "predict: predict.py\n",
+			}
+			if len(files) != len(want) {
+				t.Errorf("fetched %v, want %v", files, want)
+			}
+			for path, body := range want {
+				if files[path] != body {
+					t.Errorf("fetched %s is %q, want %q", path, files[path], body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	gcache "github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/replicate/yolo/pkg/cache"
+)
+
+// Cached wraps a backend so that images pulled by digest are served from c.
+// Tags are still resolved by the backend, so they see the latest push.
+// Only the layers of bases that yolo builds on are cached, clones and copies
+// pass theirs straight through.
+func Cached(b Backend, c *cache.Cache) Backend {
+	return &cachedBackend{inner: b, cache: c}
+}
+
+type cachedBackend struct {
+	inner Backend
+	cache *cache.Cache
+}
+
+func (b *cachedBackend) Image(ctx context.Context, ref name.Reference) (v1.Image, error) {
+	var digest v1.Hash
+	if d, ok := ref.(name.Digest); ok {
+		h, err := v1.NewHash(d.DigestStr())
+		if err != nil {
+			return nil, err
+		}
+		digest = h
+	} else {
+		h, err := b.inner.Digest(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		digest = h
+		ref = ref.Context().Digest(h.String())
+	}
+
+	if img, err := b.cached(ctx, ref, digest); err == nil {
+		logFrom(ctx).Debug("using cached metadata", "ref", ref.Name())
+		return img, nil
+	}
+
+	img, err := b.inner.Image(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	b.store(img)
+	return img, nil
+}
+
+// cacheLayers returns img with its layers read from the cache, and cached as
+// they are read
+func (b *cachedBackend) cacheLayers(img v1.Image) v1.Image {
+	return gcache.Image(img, b.cache.LayerCache())
+}
+
+func (b *cachedBackend) Digest(ctx context.Context, ref name.Reference) (v1.Hash, error) {
+	return b.inner.Digest(ctx, ref)
+}
+
+func (b *cachedBackend) Write(ctx context.Context, ref name.Reference, img v1.Image) error {
+	if err := b.inner.Write(ctx, ref, img); err != nil {
+		return err
+	}
+	// the next push usually builds on this one
+	b.store(img)
+	return nil
+}
+
+// CheckPush is passed on to backends that support it
+func (b *cachedBackend) CheckPush(ctx context.Context, ref name.Reference) error {
+	if checker, ok := b.inner.(pushChecker); ok {
+		return checker.CheckPush(ctx, ref)
+	}
+	return nil
+}
+
+// cached builds an image from its cached manifest and config.  Layers that
+// aren't cached are fetched from the backend when they're first read.
+func (b *cachedBackend) cached(ctx context.Context, ref name.Reference, digest v1.Hash) (v1.Image, error) {
+	raw, err := b.cache.Get(cache.Manifests, digest)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := v1.ParseManifest(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	config, err := b.cache.Get(cache.Configs, manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return partial.CompressedToImage(&cachedImage{
+		raw:      raw,
+		manifest: manifest,
+		config:   config,
+		remote: sync.OnceValues(func() (v1.Image, error) {
+			return b.inner.Image(ctx, ref)
+		}),
+	})
+}
+
+// store caches img's manifest and config.  Images that don't have a
+// manifest of their own, like those picked from an index, aren't cached.
+func (b *cachedBackend) store(img v1.Image) {
+	digest, err := img.Digest()
+	if err != nil {
+		return
+	}
+	raw, err := img.RawManifest()
+	if err != nil {
+		return
+	}
+	config, err := img.RawConfigFile()
+	if err != nil {
+		return
+	}
+	configName, err := img.ConfigName()
+	if err != nil {
+		return
+	}
+
+	// Put checks the digests, so a mismatched manifest is just not cached
+	if err := b.cache.Put(cache.Configs, configName, config); err != nil {
+		return
+	}
+	_ = b.cache.Put(cache.Manifests, digest, raw)
+}
+
+// cachedImage is an image whose manifest and config came from the cache
+type cachedImage struct {
+	raw      []byte
+	manifest *v1.Manifest
+	config   []byte
+	remote   func() (v1.Image, error)
+}
+
+func (i *cachedImage) RawConfigFile() ([]byte, error) {
+	return i.config, nil
+}
+
+func (i *cachedImage) MediaType() (types.MediaType, error) {
+	if i.manifest.MediaType != "" {
+		return i.manifest.MediaType, nil
+	}
+	return types.DockerManifestSchema2, nil
+}
+
+func (i *cachedImage) RawManifest() ([]byte, error) {
+	return i.raw, nil
+}
+
+func (i *cachedImage) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	config, err := v1.ParseConfigFile(bytes.NewReader(i.config))
+	if err != nil {
+		return nil, err
+	}
+
+	for n, desc := range i.manifest.Layers {
+		if desc.Digest != h {
+			continue
+		}
+		if n >= len(config.RootFS.DiffIDs) {
+			return nil, fmt.Errorf("layer %s has no diff id", h)
+		}
+		return &remoteLayer{desc: desc, diffID: config.RootFS.DiffIDs[n], image: i.remote}, nil
+	}
+	return nil, fmt.Errorf("layer %s not found", h)
+}
+
+// remoteLayer fetches a layer from the backend when it is read
+type remoteLayer struct {
+	desc   v1.Descriptor
+	diffID v1.Hash
+	image  func() (v1.Image, error)
+}
+
+func (l *remoteLayer) Digest() (v1.Hash, error) {
+	return l.desc.Digest, nil
+}
+
+// DiffID comes from the config, so that partial doesn't read the layer to
+// compute it
+func (l *remoteLayer) DiffID() (v1.Hash, error) {
+	return l.diffID, nil
+}
+
+func (l *remoteLayer) Size() (int64, error) {
+	return l.desc.Size, nil
+}
+
+func (l *remoteLayer) MediaType() (types.MediaType, error) {
+	return l.desc.MediaType, nil
+}
+
+func (l *remoteLayer) Compressed() (io.ReadCloser, error) {
+	img, err := l.image()
+	if err != nil {
+		return nil, err
+	}
+	layer, err := img.LayerByDigest(l.desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return layer.Compressed()
+}
@@ -1,43 +1,190 @@
 package images
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
-	"os"
+	"strings"
 	"time"
 
-	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/crane"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/replicate/yolo/pkg/auth"
+	"github.com/replicate/yolo/pkg/errdefs"
 )
 
-func Clone(baseRef string, dest string, session authn.Authenticator) (string, error) {
+// DefaultCloneLabel marks clones so that they get a digest of their own
+const DefaultCloneLabel = "cloned"
 
-	fmt.Fprintln(os.Stderr, "fetching metadata for", baseRef)
-	base, err := crane.Pull(baseRef, crane.WithAuth(session))
+// when clones get the marker label.  Only marked clones get provenance:
+// the others are pushed unchanged, so they keep the base's digest.
+const (
+	// MarkerAuto only marks clones pushed to r8.im, which refuses to push
+	// an image it already has under another name
+	MarkerAuto = "auto"
+	// MarkerAlways marks every clone
+	MarkerAlways = "always"
+	// MarkerNever marks no clone
+	MarkerNever = "never"
+)
+
+// CloneOptions describes a clone
+type CloneOptions struct {
+	Config
+
+	// BaseRef is the image to clone
+	BaseRef string
+	// Base is the image BaseRef points to, if it was already pulled
+	Base v1.Image
+	// Dests are where the clone is pushed, the base is only pulled once
+	Dests []string
+	// StripYolo removes every yolo layer, leaving the upstream model
+	StripYolo bool
+	// ResetConfig restores the env and labels of the original base, when
+	// BaseRef has provenance
+	ResetConfig bool
+	// Label is the marker, as key or key=value.  The value defaults to the
+	// time of the push, and the key to DefaultCloneLabel.
+	Label string
+	// Marker is MarkerAuto, MarkerAlways or MarkerNever, MarkerAuto if empty
+	Marker string
+}
+
+// Clone pushes a copy of o.BaseRef to each of o.Dests and returns their
+// image ids, in the same order
+func Clone(ctx context.Context, o CloneOptions) ([]string, error) {
+	ctx = withConfig(ctx, o.Config)
+
+	if o.Marker == "" {
+		o.Marker = MarkerAuto
+	}
+	if err := CheckMarker(o.Marker); err != nil {
+		return nil, err
+	}
+	labelKey, labelValue, _ := strings.Cut(o.Label, "=")
+	if labelKey == "" {
+		labelKey = DefaultCloneLabel
+	}
+
+	var err error
+	base := o.Base
+	if base == nil {
+		logFrom(ctx).Info("fetching metadata", "ref", o.BaseRef)
+		base, err = pull(ctx, o.BaseRef, o.Session)
+		if err != nil {
+			return nil, fmt.Errorf("pulling %w", err)
+		}
+	}
+
+	img := base
+	if o.StripYolo {
+		img, err = removeYolo(ctx, img)
+		if err != nil {
+			return nil, fmt.Errorf("removing existing yolo layers: %w", err)
+		}
+	}
+	if o.ResetConfig {
+		img, err = restoreOriginal(ctx, img, base, o.BaseRef, o.Session)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// provenance has the time of the clone, so only marked clones get it.
+	// The others are pushed as they are and keep the base's digest.
+	var marked v1.Image
+	var ids []string
+	for _, dest := range o.Dests {
+		out := img
+		if needsMarker(o.Config, o.Marker, dest) {
+			if marked == nil {
+				marked, err = cloneProvenance(ctx, o, base, img)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			value := labelValue
+			if value == "" {
+				value = time.Now().String()
+			}
+			out, err = setLabel(marked, labelKey, value)
+			if err != nil {
+				return nil, fmt.Errorf("setting %s label: %w", labelKey, err)
+			}
+		}
+
+		err = push(ctx, out, dest, o.Session, mountSource{o.BaseRef, base})
+		if err != nil {
+			return nil, fmt.Errorf("pushing %s: %w", dest, err)
+		}
+
+		id, err := o.ImageId(dest, out)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// cloneProvenance returns img with the provenance of a clone of base
+func cloneProvenance(ctx context.Context, o CloneOptions, base v1.Image, img v1.Image) (v1.Image, error) {
+	prov, err := newProvenance(ctx, o.BaseRef, base, "")
 	if err != nil {
-		return "", fmt.Errorf("pulling %w", err)
+		return nil, fmt.Errorf("reading provenance: %w", err)
+	}
+	if o.StripYolo {
+		// the files yolo added went with their layers
+		prov.Manifest = nil
 	}
 
-	// as r8.im fails if you push the same image to a second location,
-	// we can work around this by adding a label "cloned" with the current time
-	cfg, err := base.ConfigFile()
+	img, err = setProvenance(img, prov)
 	if err != nil {
-		return "", fmt.Errorf("getting config file: %w", err)
+		return nil, fmt.Errorf("updating provenance: %w", err)
+	}
+	return img, nil
+}
+
+// CheckMarker fails unless marker is MarkerAuto, MarkerAlways or MarkerNever
+func CheckMarker(marker string) error {
+	switch marker {
+	case MarkerAuto, MarkerAlways, MarkerNever:
+		return nil
+	}
+	return errdefs.Errorf(errdefs.InvalidInput, "unknown marker %q, expected %s, %s or %s", marker, MarkerAuto, MarkerAlways, MarkerNever)
+}
+
+// needsMarker returns whether a clone pushed to dest gets the marker label
+func needsMarker(c Config, marker string, dest string) bool {
+	switch marker {
+	case MarkerAlways:
+		return true
+	case MarkerNever:
+		return false
 	}
-	cfg.Config.Labels["cloned"] = time.Now().String()
 
-	img, err := mutate.ConfigFile(base, cfg)
+	ref, err := c.ParseReference(dest)
 	if err != nil {
-		return "", fmt.Errorf("mutating config file: %w", err)
+		// push reports the bad reference
+		return true
 	}
+	return ref.Context().RegistryStr() == auth.ReplicateRegistry
+}
 
-	start := time.Now()
-	err = crane.Push(img, dest, crane.WithAuth(session))
+// setLabel returns img with the label key set to value
+func setLabel(img v1.Image, key string, value string) (v1.Image, error) {
+	cfg, err := img.ConfigFile()
 	if err != nil {
-		return "", fmt.Errorf("pushing %s: %w", dest, err)
+		return nil, err
+	}
+
+	cfg = cfg.DeepCopy()
+	if cfg.Config.Labels == nil {
+		cfg.Config.Labels = make(map[string]string)
 	}
-	fmt.Fprintln(os.Stderr, "pushing took", time.Since(start))
+	cfg.Config.Labels[key] = value
 
-	return ImageId(dest, img)
+	return mutate.Config(img, cfg.Config)
 }
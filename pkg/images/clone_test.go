@@ -0,0 +1,66 @@
+package images
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestCloneMarker(t *testing.T) {
+	ctx := context.Background()
+
+	base, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseDigest, err := base.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		marker string
+		dest   string
+		// same is whether the clone keeps the base's digest
+		same bool
+	}{
+		{marker: MarkerNever, dest: "r8.im/acme/never", same: true},
+		{marker: MarkerNever, dest: "registry.example.com/acme/never", same: true},
+		{marker: MarkerAuto, dest: "registry.example.com/acme/auto", same: true},
+		{marker: MarkerAuto, dest: "r8.im/acme/auto", same: false},
+		{marker: MarkerAlways, dest: "registry.example.com/acme/always", same: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.marker+" "+tt.dest, func(t *testing.T) {
+			mem := NewMemory()
+			if err := mem.Write(ctx, name.MustParseReference("r8.im/acme/base:v1"), base); err != nil {
+				t.Fatal(err)
+			}
+
+			ids, err := Clone(ctx, CloneOptions{
+				Config:  Config{Backend: mem},
+				BaseRef: "r8.im/acme/base:v1",
+				Dests:   []string{tt.dest},
+				Marker:  tt.marker,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ref, err := name.ParseReference(ids[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := mem.Digest(ctx, ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if same := got == baseDigest; same != tt.same {
+				t.Errorf("clone has digest %s, base %s; want same = %v", got, baseDigest, tt.same)
+			}
+		})
+	}
+}
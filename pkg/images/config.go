@@ -0,0 +1,130 @@
+package images
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/replicate/yolo/pkg/auth"
+	"github.com/replicate/yolo/pkg/cache"
+	"github.com/replicate/yolo/pkg/logging"
+	"github.com/replicate/yolo/pkg/progress"
+)
+
+const (
+	// DefaultRegistry is used for references without a registry host
+	DefaultRegistry = auth.ReplicateRegistry
+	// DefaultAPIBaseURL is the Replicate API used to look up model versions
+	DefaultAPIBaseURL = "https://api.replicate.com"
+	// DefaultJobs is how many layers are transferred at once
+	DefaultJobs = 4
+	// DefaultRetries is how many times the CLI retries failed pulls and pushes
+	DefaultRetries = 3
+	// DefaultRetryBackoff is the delay before the first retry
+	DefaultRetryBackoff = 2 * time.Second
+)
+
+// defaultLog is used when a Config has no logger
+var defaultLog = logging.New(os.Stderr, slog.LevelInfo)
+
+// Config is what every operation runs with.  Each operation's options embed
+// one, so several callers in a process don't share any state.  The zero
+// value pulls from and pushes to r8.im anonymously, without a cache.
+type Config struct {
+	// Session authenticates registry requests
+	Session authn.Keychain
+	// Backend fetches and stores images instead of the registry
+	Backend Backend
+	// Cache keeps manifests, configs and layers of pulled images, so
+	// pulling the same base again only resolves its tag
+	Cache *cache.Cache
+
+	// Log receives everything but progress bars, info and up to stderr if nil
+	Log *slog.Logger
+	// Progress receives transfer progress, phase timings and warnings; nil
+	// discards them
+	Progress *progress.Tracker
+
+	// Jobs is how many layers are transferred at once, DefaultJobs if 0
+	Jobs int
+	// Retries is how many times a failed pull or push is retried, none if 0
+	Retries int
+	// RetryBackoff is the delay before the first retry, doubled after each
+	// attempt; DefaultRetryBackoff if 0
+	RetryBackoff time.Duration
+
+	// DefaultRegistry is used for references without a registry host, the
+	// package's DefaultRegistry if empty
+	DefaultRegistry string
+	// DefaultOwner is prepended to references that are just a model name
+	DefaultOwner string
+	// Mirrors are tried in order before the registry itself when pulling
+	Mirrors []Mirror
+	// APIBaseURL is the Replicate API, DefaultAPIBaseURL if empty
+	APIBaseURL string
+}
+
+func (c *Config) log() *slog.Logger {
+	if c.Log != nil {
+		return c.Log
+	}
+	return defaultLog
+}
+
+func (c *Config) jobs() int {
+	if c.Jobs > 0 {
+		return c.Jobs
+	}
+	return DefaultJobs
+}
+
+func (c *Config) retryBackoff() time.Duration {
+	if c.RetryBackoff > 0 {
+		return c.RetryBackoff
+	}
+	return DefaultRetryBackoff
+}
+
+func (c *Config) registry() string {
+	if c.DefaultRegistry != "" {
+		return c.DefaultRegistry
+	}
+	return DefaultRegistry
+}
+
+func (c *Config) apiBaseURL() string {
+	if c.APIBaseURL != "" {
+		return c.APIBaseURL
+	}
+	return DefaultAPIBaseURL
+}
+
+type contextKey int
+
+const (
+	configKey contextKey = iota
+	mountKey
+)
+
+// withConfig returns a context that the operation's helpers read c from
+func withConfig(ctx context.Context, c Config) context.Context {
+	return context.WithValue(ctx, configKey, &c)
+}
+
+// configFrom returns the config of the operation running in ctx
+func configFrom(ctx context.Context) *Config {
+	if c, ok := ctx.Value(configKey).(*Config); ok {
+		return c
+	}
+	return &Config{}
+}
+
+func logFrom(ctx context.Context) *slog.Logger {
+	return configFrom(ctx).log()
+}
+
+func progressFrom(ctx context.Context) *progress.Tracker {
+	return configFrom(ctx).Progress
+}
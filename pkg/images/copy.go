@@ -0,0 +1,124 @@
+package images
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/replicate/yolo/pkg/errdefs"
+)
+
+// CopyOptions describes a copy of an image between registries
+type CopyOptions struct {
+	Config
+
+	// From is the image to copy
+	From string
+	// To is where the copy is pushed, unchanged
+	To string
+	// FromSession reads From and ToSession writes To, so each side can use
+	// its own credentials.  Either defaults to Session.
+	FromSession authn.Keychain
+	ToSession   authn.Keychain
+}
+
+// Copy copies o.From to o.To without changing it, checks that o.To now
+// has the same digest and returns its image id.  Multi-platform indexes are
+// copied with all their images when the registry is the backend.
+func Copy(ctx context.Context, o CopyOptions) (string, error) {
+	ctx = withConfig(ctx, o.Config)
+	if o.FromSession == nil {
+		o.FromSession = o.Session
+	}
+	if o.ToSession == nil {
+		o.ToSession = o.Session
+	}
+
+	from, err := o.ParseReference(o.From)
+	if err != nil {
+		return "", err
+	}
+	to, err := o.ParseReference(o.To)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		want   v1.Hash
+		copied bool
+	)
+	if o.Backend == nil {
+		want, copied, err = copyIndex(ctx, from, to, o.FromSession, o.ToSession)
+		if err != nil {
+			return "", err
+		}
+	}
+	if !copied {
+		logFrom(ctx).Info("fetching metadata", "ref", o.From)
+		img, err := pull(ctx, o.From, o.FromSession)
+		if err != nil {
+			return "", fmt.Errorf("pulling %w", err)
+		}
+		want, err = img.Digest()
+		if err != nil {
+			return "", err
+		}
+
+		err = push(ctx, img, o.To, o.ToSession, mountSource{o.From, img})
+		if err != nil {
+			return "", fmt.Errorf("pushing %s: %w", o.To, err)
+		}
+	}
+
+	// read the digest back from the destination, not from what we sent
+	got, err := backendFrom(ctx, o.ToSession).Digest(ctx, to)
+	if err != nil {
+		return "", fmt.Errorf("verifying %s: %w", o.To, err)
+	}
+	if got != want {
+		return "", errdefs.Errorf(errdefs.Conflict, "%s has digest %s after copying %s, expected %s", o.To, got, o.From, want)
+	}
+	logFrom(ctx).Info("verified", "ref", o.To, "digest", got)
+
+	return to.Context().Digest(got.String()).Name(), nil
+}
+
+// copyIndex copies from to to if it is a multi-platform index, with every
+// image in it, and returns its digest.  It returns false for a single
+// image, which is copied layer by layer like any other push instead.
+func copyIndex(ctx context.Context, from name.Reference, to name.Reference, fromSession authn.Keychain, toSession authn.Keychain) (v1.Hash, bool, error) {
+	var desc *remote.Descriptor
+	err := withRetry(ctx, "fetching "+from.Name(), func() error {
+		var err error
+		desc, err = remote.Get(from, remoteOptions(ctx, fromSession)...)
+		return err
+	})
+	if err != nil {
+		return v1.Hash{}, false, fmt.Errorf("fetching %s: %w", from.Name(), err)
+	}
+	if !desc.MediaType.IsIndex() {
+		return v1.Hash{}, false, nil
+	}
+
+	idx, err := desc.ImageIndex()
+	if err != nil {
+		return v1.Hash{}, false, err
+	}
+	m, err := idx.IndexManifest()
+	if err != nil {
+		return v1.Hash{}, false, err
+	}
+	logFrom(ctx).Info("copying index", "ref", from.Name(), "manifests", len(m.Manifests))
+
+	defer progressFrom(ctx).Phase("upload")()
+	err = withRetry(ctx, "pushing "+to.Name(), func() error {
+		return remote.WriteIndex(to, idx, remoteOptions(ctx, toSession)...)
+	})
+	if err != nil {
+		return v1.Hash{}, false, fmt.Errorf("pushing %s: %w", to.Name(), err)
+	}
+	return desc.Digest, true, nil
+}
@@ -2,6 +2,7 @@ package images
 
 import (
 	"archive/tar"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -9,23 +10,38 @@ import (
 	"time"
 
 	"github.com/dustin/go-humanize"
-	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/crane"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/replicate/yolo/pkg/errdefs"
+	"golang.org/x/sync/errgroup"
 )
 
-func Extract(baseRef string, dest string, session authn.Authenticator) error {
+// ExtractOptions describes a fetch
+type ExtractOptions struct {
+	Config
+
+	// BaseRef is the image whose /src is extracted
+	BaseRef string
+	// Dest is the directory to extract to, which must not exist yet
+	Dest string
+}
+
+// Extract writes the merged /src layers of o.BaseRef, cog's and yolo's, to
+// o.Dest
+func Extract(ctx context.Context, o ExtractOptions) error {
+	ctx = withConfig(ctx, o.Config)
+	baseRef, dest, session := o.BaseRef, o.Dest, o.Session
+
 	var err error
 
 	if _, err = os.Stat(dest); !os.IsNotExist(err) {
-		return fmt.Errorf("destination %s already exists", dest)
+		return errdefs.Errorf(errdefs.InvalidInput, "destination %s already exists", dest)
 	}
 
 	var base v1.Image
 
-	fmt.Fprintln(os.Stderr, "fetching metadata for", baseRef)
+	logFrom(ctx).Info("fetching metadata", "ref", baseRef)
 
-	base, err = crane.Pull(baseRef, crane.WithAuth(session))
+	base, err = pullBase(ctx, baseRef, session)
 	if err != nil {
 		return fmt.Errorf("pulling %w", err)
 	}
@@ -35,23 +51,127 @@ func Extract(baseRef string, dest string, session authn.Authenticator) error {
 		return err
 	}
 
-	for _, layer := range src {
-		rc, err := layer.Uncompressed()
-		if err != nil {
-			return err
+	defer progressFrom(ctx).Phase("extract")()
+	return extractLayers(ctx, src, dest)
+}
+
+// extractLayers downloads and decompresses up to Jobs layers at once into
+// temporary tarballs, and extracts each into dest as soon as the layers
+// before it are, so later layers still overwrite earlier ones.  The tarballs
+// go to $TMPDIR, and no more than Jobs of them are kept there at once.
+func extractLayers(ctx context.Context, layers []v1.Layer, dest string) error {
+	tmp, err := os.MkdirTemp("", "yolo-fetch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	ctx, cancel := context.WithCancel(ctx)
+	g, gctx := errgroup.WithContext(ctx)
+
+	// a slot is taken before a layer is downloaded and given back once it
+	// is extracted, so downloads don't run ahead of extraction
+	slots := make(chan struct{}, configFrom(ctx).jobs())
+
+	ready := make([]chan string, len(layers))
+	for i := range ready {
+		ready[i] = make(chan string, 1)
+	}
+
+	// waiting for slots blocks, so start the downloads aside
+	started := make(chan struct{})
+	go func() {
+		defer close(started)
+		for i, layer := range layers {
+			i, layer := i, layer
+			select {
+			case slots <- struct{}{}:
+			case <-gctx.Done():
+				return
+			}
+			g.Go(func() error {
+				var path string
+				err := withRetry(gctx, fmt.Sprintf("downloading layer %d", i+1), func() error {
+					var err error
+					path, err = spoolLayer(gctx, layer, tmp)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				ready[i] <- path
+				return nil
+			})
 		}
+	}()
 
-		tr := tar.NewReader(rc)
-		err = extractTarFile(tr, dest)
-		if err != nil {
+	// stop and wait for the downloads before tmp is removed
+	wait := func() error {
+		<-started
+		return g.Wait()
+	}
+	defer func() {
+		cancel()
+		wait()
+	}()
+
+	for i := range layers {
+		var path string
+		select {
+		case path = <-ready[i]:
+		case <-gctx.Done():
+			// the first error, or why ctx was canceled
+			if err := wait(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		}
+
+		if err := extractTarPath(ctx, path, dest); err != nil {
 			return err
 		}
+		os.Remove(path)
+		<-slots
 	}
+	return wait()
+}
 
-	return nil
+// spoolLayer writes layer's uncompressed tarball to a file in dir
+func spoolLayer(ctx context.Context, layer v1.Layer, dir string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	f, err := os.CreateTemp(dir, "layer-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, rc); err != nil {
+		// a retry starts over in a new file
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+func extractTarPath(ctx context.Context, path string, destDir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return extractTarFile(ctx, tar.NewReader(f), destDir)
 }
 
-func extractTarFile(tarReader *tar.Reader, destDir string) error {
+func extractTarFile(ctx context.Context, tarReader *tar.Reader, destDir string) error {
 	startTime := time.Now()
 	var _fileSize int64
 
@@ -76,8 +196,8 @@ func extractTarFile(tarReader *tar.Reader, destDir string) error {
 				return err
 			}
 		case tar.TypeReg:
-			fmt.Fprintln(os.Stderr, target)
-			targetFile, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, os.FileMode(header.Mode))
+			logFrom(ctx).Debug("extracted", "path", target)
+			targetFile, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
 			if err != nil {
 				return err
 			}
@@ -96,10 +216,9 @@ func extractTarFile(tarReader *tar.Reader, destDir string) error {
 		}
 	}
 
-	elapsed := time.Since(startTime).Seconds()
-	size := humanize.Bytes(uint64(_fileSize))
-	throughput := humanize.Bytes(uint64(float64(_fileSize) / elapsed))
-	fmt.Fprintf(os.Stderr, "Extracted %s in %.3fs (%s/s)\n", size, elapsed, throughput)
+	elapsed := time.Since(startTime)
+	throughput := humanize.Bytes(uint64(float64(_fileSize) / elapsed.Seconds()))
+	logFrom(ctx).Info("extracted", "size", humanize.Bytes(uint64(_fileSize)), "duration", elapsed.Round(time.Millisecond), "throughput", throughput+"/s")
 
 	return nil
 }
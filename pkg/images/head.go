@@ -0,0 +1,77 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/replicate/yolo/pkg/errdefs"
+)
+
+// HeadDigest returns the digest dest currently points to, or "" if nothing
+// has been pushed there yet
+func (c Config) HeadDigest(ctx context.Context, dest string) (string, error) {
+	return headDigest(withConfig(ctx, c), dest, c.Session)
+}
+
+func headDigest(ctx context.Context, dest string, session authn.Keychain) (string, error) {
+	ref, err := configFrom(ctx).ParseReference(dest)
+	if err != nil {
+		return "", err
+	}
+
+	d, err := backendFrom(ctx, session).Digest(ctx, ref)
+	if errdefs.KindOf(err) == errdefs.NotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getting digest of %s: %w", dest, err)
+	}
+	return d.String(), nil
+}
+
+// checkHead aborts if ifMatch is set and dest no longer points to it, and
+// warns if dest has moved past the base we're building on
+func checkHead(ctx context.Context, baseRef string, base string, dest string, ifMatch string, session authn.Keychain) error {
+	head, err := headDigest(ctx, dest, session)
+	if err != nil {
+		return err
+	}
+
+	if ifMatch != "" {
+		want := normalizeDigest(ifMatch)
+		if head != want {
+			if head == "" {
+				head = "nothing"
+			}
+			return errdefs.Errorf(errdefs.Conflict, "%s is at %s, not %s: someone else pushed in the meantime", dest, head, want)
+		}
+	}
+
+	baseRepo, err := configFrom(ctx).Repository(baseRef)
+	if err != nil {
+		return err
+	}
+	destRepo, err := configFrom(ctx).Repository(dest)
+	if err != nil {
+		return err
+	}
+	if head != "" && baseRepo == destRepo && head != base {
+		progressFrom(ctx).Warn("building on %s but %s is at %s, changes pushed since will be replaced", base, dest, head)
+	}
+
+	return nil
+}
+
+// normalizeDigest accepts sha256:<hex>, a bare version id or a full
+// reference ending in @sha256:<hex>
+func normalizeDigest(d string) string {
+	if i := strings.LastIndex(d, "@"); i >= 0 {
+		d = d[i+1:]
+	}
+	if versionIdPattern.MatchString(d) {
+		d = "sha256:" + d
+	}
+	return d
+}
@@ -0,0 +1,129 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/replicate/yolo/pkg/errdefs"
+)
+
+// the OCI annotation layouts use to name images
+const refNameAnnotation = "org.opencontainers.image.ref.name"
+
+// Layout stores images in an OCI image layout directory, named by their full
+// reference
+type Layout struct {
+	Path string
+}
+
+func (l *Layout) Image(ctx context.Context, ref name.Reference) (v1.Image, error) {
+	p, desc, err := l.find(ref)
+	if err != nil {
+		return nil, err
+	}
+	return p.Image(desc.Digest)
+}
+
+func (l *Layout) Digest(ctx context.Context, ref name.Reference) (v1.Hash, error) {
+	_, desc, err := l.find(ref)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	return desc.Digest, nil
+}
+
+func (l *Layout) Write(ctx context.Context, ref name.Reference, img v1.Image) error {
+	p, err := layout.FromPath(l.Path)
+	if err != nil {
+		p, err = layout.Write(l.Path, empty.Index)
+		if err != nil {
+			return fmt.Errorf("creating layout %s: %w", l.Path, err)
+		}
+	}
+
+	return p.ReplaceImage(img, match.Annotation(refNameAnnotation, ref.Name()),
+		layout.WithAnnotations(map[string]string{refNameAnnotation: ref.Name()}))
+}
+
+func (l *Layout) find(ref name.Reference) (layout.Path, *v1.Descriptor, error) {
+	p, err := layout.FromPath(l.Path)
+	if err != nil {
+		return "", nil, errdefs.Wrap(errdefs.NotFound, fmt.Errorf("reading layout %s: %w", l.Path, err))
+	}
+	index, err := p.ImageIndex()
+	if err != nil {
+		return "", nil, err
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return "", nil, err
+	}
+
+	d, isDigest := ref.(name.Digest)
+	for _, desc := range manifest.Manifests {
+		desc := desc
+		if desc.Annotations[refNameAnnotation] == ref.Name() || (isDigest && desc.Digest.String() == d.DigestStr()) {
+			return p, &desc, nil
+		}
+	}
+	return "", nil, errdefs.Errorf(errdefs.NotFound, "%s not found in %s", ref.Name(), l.Path)
+}
+
+// Tarball stores images in a docker save style tarball.  Writing replaces
+// whatever the tarball held before.
+type Tarball struct {
+	Path string
+}
+
+func (t *Tarball) Image(ctx context.Context, ref name.Reference) (v1.Image, error) {
+	if _, err := os.Stat(t.Path); errors.Is(err, os.ErrNotExist) {
+		return nil, errdefs.Errorf(errdefs.NotFound, "%s not found", t.Path)
+	}
+
+	// digest references can only be read from tarballs holding one image
+	var tag *name.Tag
+	if tg, ok := ref.(name.Tag); ok {
+		tag = &tg
+	}
+	img, err := tarball.ImageFromPath(t.Path, tag)
+	if err != nil {
+		return nil, errdefs.Wrap(errdefs.NotFound, fmt.Errorf("reading %s from %s: %w", ref.Name(), t.Path, err))
+	}
+
+	if d, ok := ref.(name.Digest); ok {
+		got, err := img.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if got.String() != d.DigestStr() {
+			return nil, errdefs.Errorf(errdefs.NotFound, "%s not found in %s", ref.Name(), t.Path)
+		}
+	}
+	return img, nil
+}
+
+func (t *Tarball) Digest(ctx context.Context, ref name.Reference) (v1.Hash, error) {
+	img, err := t.Image(ctx, ref)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	return img.Digest()
+}
+
+func (t *Tarball) Write(ctx context.Context, ref name.Reference, img v1.Image) error {
+	// img may be read from the tarball being replaced
+	tmp := t.Path + ".tmp"
+	if err := tarball.WriteToFile(tmp, ref, img); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing %s: %w", t.Path, err)
+	}
+	return os.Rename(tmp, t.Path)
+}
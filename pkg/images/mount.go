@@ -0,0 +1,108 @@
+package images
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// mountSource is an image whose layers pushes can mount instead of
+// uploading them
+type mountSource struct {
+	ref string
+	img v1.Image
+}
+
+// withMountFrom returns a context that makes pushes mount the layers of
+// sources from their repositories instead of uploading them.  Only the
+// digests in each source's manifest are tried, so the registry isn't asked
+// for blobs it can't have.
+func withMountFrom(ctx context.Context, sources ...mountSource) (context.Context, error) {
+	layers := map[v1.Hash][]name.Repository{}
+	for _, s := range sources {
+		if s.ref == "" || s.img == nil {
+			continue
+		}
+		r, err := configFrom(ctx).ParseReference(s.ref)
+		if err != nil {
+			return nil, err
+		}
+		m, err := s.img.Manifest()
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range m.Layers {
+			layers[l.Digest] = append(layers[l.Digest], r.Context())
+		}
+	}
+	if len(layers) == 0 {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, mountKey, layers), nil
+}
+
+// mountFrom returns the repository to mount blob d into repo from, or false
+// if no source has it.  Blobs can only be mounted within a registry.
+func mountFrom(ctx context.Context, repo name.Repository, d v1.Hash) (name.Repository, bool) {
+	layers, _ := ctx.Value(mountKey).(map[v1.Hash][]name.Repository)
+	for _, r := range layers[d] {
+		if r.RegistryStr() == repo.RegistryStr() && r.RepositoryStr() != repo.RepositoryStr() {
+			return r, true
+		}
+	}
+	return name.Repository{}, false
+}
+
+// blobWatcher sees the registry's answers while one blob is written, since
+// ggcr doesn't tell whether it uploaded, mounted or skipped it
+type blobWatcher struct {
+	inner   http.RoundTripper
+	existed atomic.Bool
+	mounted atomic.Bool
+}
+
+func (w *blobWatcher) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := w.inner.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	switch {
+	case req.Method == http.MethodHead && strings.Contains(req.URL.Path, "/blobs/") && resp.StatusCode == http.StatusOK:
+		// ggcr's existence check, the blob isn't sent
+		w.existed.Store(true)
+	case req.Method == http.MethodPost && req.URL.Query().Get("mount") != "" && resp.StatusCode == http.StatusCreated:
+		w.mounted.Store(true)
+	}
+	return resp, nil
+}
+
+// countingLayer reports the compressed bytes read from a layer, which are
+// the bytes uploaded
+type countingLayer struct {
+	v1.Layer
+	add func(n int64)
+}
+
+func (l countingLayer) Compressed() (io.ReadCloser, error) {
+	rc, err := l.Layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	return countingReader{rc, l.add}, nil
+}
+
+type countingReader struct {
+	io.ReadCloser
+	add func(n int64)
+}
+
+func (r countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.add(int64(n))
+	return n, err
+}
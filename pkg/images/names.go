@@ -1,28 +1,133 @@
 package images
 
 import (
+	"context"
 	"fmt"
+	"regexp"
 	"strings"
 
+	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/replicate/yolo/pkg/auth"
+	"github.com/replicate/yolo/pkg/errdefs"
 )
 
-func EnsureRegistry(baseRef string) string {
-	if strings.Contains(baseRef, ":") && !strings.Contains(baseRef, "@") {
-		sha := strings.Split(baseRef, ":")[1]
-		baseRef = strings.Split(baseRef, ":")[0] + "@sha256:" + sha
+var (
+	// a replicate version id is the hex sha256 of the image, without the
+	// "sha256:" prefix
+	versionIdPattern = regexp.MustCompile(`^[a-f0-9]{64}$`)
+	// a short version id is a prefix of one, like git's short hashes
+	shortVersionIdPattern = regexp.MustCompile(`^[a-f0-9]{7,63}$`)
+)
+
+// ParseReference parses an image reference with the default registry and no
+// default owner, see Config.ParseReference
+func ParseReference(s string) (name.Reference, error) {
+	return Config{}.ParseReference(s)
+}
+
+// ParseReference parses an image reference.  It accepts:
+//
+//	owner/model                          (c's DefaultRegistry, latest)
+//	model                                (c's DefaultOwner/model, if set)
+//	owner/model:tag                      (tag)
+//	owner/model:<version id>             (digest)
+//	owner/model:<short version id>       (tag, see ResolveBase)
+//	owner/model@<version id>             (digest)
+//	owner/model@sha256:<hex>             (digest)
+//	localhost:5000/owner/model:tag       (any of the above with a registry host)
+func (c Config) ParseReference(s string) (name.Reference, error) {
+	if s == "" {
+		return nil, errdefs.Errorf(errdefs.InvalidInput, "image reference is required")
+	}
+	if c.DefaultOwner != "" && !strings.Contains(s, "/") {
+		s = c.DefaultOwner + "/" + s
+	}
+	opts := []name.Option{name.WithDefaultRegistry(c.registry())}
+
+	if base, dig, found := strings.Cut(s, "@"); found {
+		if strings.Contains(dig, "@") {
+			return nil, errdefs.Errorf(errdefs.InvalidInput, "invalid image reference %q: more than one '@'", s)
+		}
+		if strings.Contains(base[strings.LastIndex(base, "/")+1:], ":") {
+			return nil, errdefs.Errorf(errdefs.InvalidInput, "ambiguous image reference %q: has both a tag and a digest", s)
+		}
+		if versionIdPattern.MatchString(dig) {
+			dig = "sha256:" + dig
+		}
+		d, err := name.NewDigest(base+"@"+dig, opts...)
+		if err != nil {
+			return nil, errdefs.Errorf(errdefs.InvalidInput, "invalid image reference %q: %w", s, err)
+		}
+		return d, nil
+	}
+
+	t, err := name.NewTag(s, opts...)
+	if err != nil {
+		return nil, errdefs.Errorf(errdefs.InvalidInput, "invalid image reference %q: %w", s, err)
 	}
-	if !strings.Contains(baseRef, "r8.im") {
-		return "r8.im/" + baseRef
+
+	if versionIdPattern.MatchString(t.TagStr()) {
+		return t.Context().Digest("sha256:" + t.TagStr()), nil
+	}
+
+	return t, nil
+}
+
+// EnsureRegistry returns the fully qualified form of an image reference
+func (c Config) EnsureRegistry(baseRef string) (string, error) {
+	ref, err := c.ParseReference(baseRef)
+	if err != nil {
+		return "", err
 	}
-	return baseRef
+	return ref.Name(), nil
 }
 
-func ImageId(baseRef string, img v1.Image) (string, error) {
+// ResolveDigest returns the digest reference that ref currently points to
+func (c Config) ResolveDigest(ctx context.Context, ref string) (string, error) {
+	ctx = withConfig(ctx, c)
+
+	r, err := c.ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	if d, ok := r.(name.Digest); ok {
+		return d.Name(), nil
+	}
+
+	d, err := backendFrom(ctx, c.Session).Digest(ctx, r)
+	if err != nil {
+		return "", fmt.Errorf("getting digest of %s: %w", ref, err)
+	}
+
+	return r.Context().Digest(d.String()).Name(), nil
+}
+
+// Repository returns the fully qualified repository of ref, without tag or
+// digest
+func (c Config) Repository(ref string) (string, error) {
+	r, err := c.ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	return r.Context().Name(), nil
+}
+
+// ImageId returns the digest reference of img in baseRef's repository
+func (c Config) ImageId(baseRef string, img v1.Image) (string, error) {
+	ref, err := c.ParseReference(baseRef)
+	if err != nil {
+		return "", err
+	}
+
 	d, err := img.Digest()
 	if err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("%s@%s", EnsureRegistry(baseRef), d), nil
+	return ref.Context().Digest(d.String()).Name(), nil
+}
+
+func isReplicate(repo name.Repository) bool {
+	return repo.RegistryStr() == auth.ReplicateRegistry
 }
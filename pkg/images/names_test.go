@@ -0,0 +1,174 @@
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/replicate/yolo/pkg/errdefs"
+)
+
+const (
+	sdxlVersion  = "1bfb924045802467cf8869d96b231a12e6aa994abfe37e337c63a4e49a8c6c41"
+	otherVersion = "1bfb9240aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		// err is the kind of error expected, Unknown for none
+		err errdefs.Kind
+	}{
+		{in: "stability-ai/sdxl", want: "r8.im/stability-ai/sdxl:latest"},
+		{in: "r8.im/stability-ai/sdxl", want: "r8.im/stability-ai/sdxl:latest"},
+		{in: "stability-ai/sdxl:v1", want: "r8.im/stability-ai/sdxl:v1"},
+		{in: "stability-ai/sdxl:20240101", want: "r8.im/stability-ai/sdxl:20240101"},
+		{in: "stability-ai/sdxl:1bfb9240", want: "r8.im/stability-ai/sdxl:1bfb9240"},
+		{in: "stability-ai/sdxl:" + sdxlVersion, want: "r8.im/stability-ai/sdxl@sha256:" + sdxlVersion},
+		{in: "stability-ai/sdxl@" + sdxlVersion, want: "r8.im/stability-ai/sdxl@sha256:" + sdxlVersion},
+		{in: "r8.im/stability-ai/sdxl@sha256:" + sdxlVersion, want: "r8.im/stability-ai/sdxl@sha256:" + sdxlVersion},
+		{in: "localhost:5000/owner/model", want: "localhost:5000/owner/model:latest"},
+		{in: "localhost:5000/owner/model:tag", want: "localhost:5000/owner/model:tag"},
+		{in: "localhost:5000/owner/model@sha256:" + sdxlVersion, want: "localhost:5000/owner/model@sha256:" + sdxlVersion},
+		{in: "ghcr.io/org/model:" + sdxlVersion, want: "ghcr.io/org/model@sha256:" + sdxlVersion},
+
+		{in: "", err: errdefs.InvalidInput},
+		{in: "owner/model:tag@sha256:" + sdxlVersion, err: errdefs.InvalidInput},
+		{in: "owner/model@" + sdxlVersion + "@" + sdxlVersion, err: errdefs.InvalidInput},
+		{in: "owner/model@1bfb9240", err: errdefs.InvalidInput},
+		{in: "Owner/Model", err: errdefs.InvalidInput},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			ref, err := ParseReference(tt.in)
+			if tt.err != errdefs.Unknown {
+				if errdefs.KindOf(err) != tt.err {
+					t.Fatalf("ParseReference(%q) = %v, want a %s error", tt.in, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseReference(%q): %v", tt.in, err)
+			}
+			if ref.Name() != tt.want {
+				t.Errorf("ParseReference(%q) = %s, want %s", tt.in, ref.Name(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReferenceDefaultOwner(t *testing.T) {
+	c := Config{DefaultOwner: "acme"}
+
+	ref, err := c.ParseReference("model:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "r8.im/acme/model:v1"; ref.Name() != want {
+		t.Errorf("got %s, want %s", ref.Name(), want)
+	}
+}
+
+func TestResolveBase(t *testing.T) {
+	// the API token mustn't be sent to other hosts
+	var offsiteHit atomic.Bool
+	offsite := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offsiteHit.Store(true)
+		json.NewEncoder(w).Encode(map[string]any{"results": []map[string]string{{"id": sdxlVersion}}})
+	}))
+	defer offsite.Close()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/models/stability-ai/sdxl":
+			json.NewEncoder(w).Encode(map[string]any{"latest_version": map[string]string{"id": sdxlVersion}})
+		case "/v1/models/stability-ai/sdxl/versions":
+			// two pages, so the prefix is looked for in both
+			if r.URL.Query().Get("page") == "" {
+				json.NewEncoder(w).Encode(map[string]any{
+					"next":    "http://" + r.Host + r.URL.Path + "?page=2",
+					"results": []map[string]string{{"id": sdxlVersion}},
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"results": []map[string]string{{"id": otherVersion}}})
+		case "/v1/models/acme/flaky/versions":
+			// a match on the first page, then a failure
+			if r.URL.Query().Get("page") == "" {
+				json.NewEncoder(w).Encode(map[string]any{
+					"next":    "?page=2",
+					"results": []map[string]string{{"id": sdxlVersion}},
+				})
+				return
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/v1/models/acme/offsite/versions":
+			json.NewEncoder(w).Encode(map[string]any{
+				"next":    offsite.URL + r.URL.Path + "?page=2",
+				"results": []map[string]string{{"id": otherVersion}},
+			})
+		case "/v1/models/acme/endless/versions":
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			json.NewEncoder(w).Encode(map[string]any{
+				"next":    fmt.Sprintf("?page=%d", page+1),
+				"results": []map[string]string{},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+
+	c := Config{APIBaseURL: api.URL}
+
+	tests := []struct {
+		in   string
+		want string
+		err  errdefs.Kind
+	}{
+		{in: "stability-ai/sdxl", want: "r8.im/stability-ai/sdxl@sha256:" + sdxlVersion},
+		{in: "stability-ai/sdxl:1bfb92404580", want: "r8.im/stability-ai/sdxl@sha256:" + sdxlVersion},
+		{in: "stability-ai/sdxl:1bfb9240aa", want: "r8.im/stability-ai/sdxl@sha256:" + otherVersion},
+		{in: "stability-ai/sdxl:20240101", want: "r8.im/stability-ai/sdxl:20240101"},
+		{in: "stability-ai/sdxl:v1", want: "r8.im/stability-ai/sdxl:v1"},
+		{in: "localhost:5000/owner/model", want: "localhost:5000/owner/model:latest"},
+		{in: "acme/offsite:1bfb92404580", want: "r8.im/acme/offsite:1bfb92404580"},
+		{in: "acme/endless:1bfb92404580", want: "r8.im/acme/endless:1bfb92404580"},
+		{in: "stability-ai/sdxl:1bfb9240", err: errdefs.InvalidInput},
+		{in: "nobody/nothing", err: errdefs.NotFound},
+		{in: "acme/flaky:1bfb92404580", err: errdefs.Retryable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := c.ResolveBase(context.Background(), tt.in, "")
+			if tt.err != errdefs.Unknown {
+				if errdefs.KindOf(err) != tt.err {
+					t.Fatalf("ResolveBase(%q) = %v, want a %s error", tt.in, err, tt.err)
+				}
+				if tt.err == errdefs.InvalidInput && !strings.Contains(err.Error(), "ambiguous") {
+					t.Errorf("ResolveBase(%q) = %v, want an ambiguous version error", tt.in, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveBase(%q): %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveBase(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	if offsiteHit.Load() {
+		t.Error("followed a next page on another host")
+	}
+}
@@ -0,0 +1,169 @@
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/replicate/yolo/pkg/errdefs"
+)
+
+// PreflightCheck describes a push or clone to validate before any upload
+type PreflightCheck struct {
+	Config
+
+	BaseRef string
+	// Base is the image BaseRef points to, pulled if nil
+	Base v1.Image
+	Dest string
+	// Files are local paths that will be added to the image
+	Files []string
+	// MaxSize is the budget for the total size of Files, 0 for no limit
+	MaxSize int64
+	Schema  string
+}
+
+// pushChecker is implemented by backends that can tell whether a push will
+// be allowed before uploading anything
+type pushChecker interface {
+	CheckPush(ctx context.Context, ref name.Reference) error
+}
+
+// PreflightError collects every problem found by Preflight
+type PreflightError struct {
+	Problems []error
+}
+
+func (e *PreflightError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "preflight found %d problems:", len(e.Problems))
+	for _, p := range e.Problems {
+		fmt.Fprintf(&b, "\n  - %s", p)
+	}
+	return b.String()
+}
+
+// Preflight checks that a push can succeed: dest is writable, the base is a
+// cog image with a source layer, all files exist and fit the size budget, and
+// the schema is valid.  All problems are reported at once.
+func Preflight(ctx context.Context, c PreflightCheck) error {
+	ctx = withConfig(ctx, c.Config)
+	session := c.Session
+
+	var problems []error
+
+	logFrom(ctx).Info("running preflight checks")
+
+	destRef, err := c.ParseReference(c.Dest)
+	if err != nil {
+		problems = append(problems, err)
+	} else if checker, ok := backendFrom(ctx, session).(pushChecker); ok {
+		if err := checker.CheckPush(ctx, destRef); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	if err := checkCogImage(ctx, c.BaseRef, c.Base, session); err != nil {
+		problems = append(problems, err)
+	}
+
+	var total int64
+	for _, path := range c.Files {
+		info, err := os.Stat(path)
+		if err != nil {
+			problems = append(problems, errdefs.Wrap(errdefs.InvalidInput, err))
+			continue
+		}
+		if info.IsDir() {
+			problems = append(problems, errdefs.Errorf(errdefs.InvalidInput, "%s is a directory", path))
+			continue
+		}
+		total += info.Size()
+	}
+	if c.MaxSize > 0 && total > c.MaxSize {
+		problems = append(problems, errdefs.Errorf(errdefs.InvalidInput, "files total %s, more than the %s budget", humanize.Bytes(uint64(total)), humanize.Bytes(uint64(c.MaxSize))))
+	}
+
+	if c.Schema != "" {
+		if err := validateSchema(c.Schema); err != nil {
+			problems = append(problems, errdefs.Wrap(errdefs.InvalidInput, fmt.Errorf("invalid schema: %w", err)))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	kind := errdefs.KindOf(problems[0])
+	if kind == errdefs.Unknown {
+		kind = errdefs.InvalidInput
+	}
+	return errdefs.Wrap(kind, &PreflightError{Problems: problems})
+}
+
+func checkCogImage(ctx context.Context, baseRef string, base v1.Image, session authn.Keychain) error {
+	if base == nil {
+		var err error
+		base, err = pull(ctx, baseRef, session)
+		if err != nil {
+			return errdefs.Wrap(errdefs.KindOf(err), fmt.Errorf("pulling %w", err))
+		}
+	}
+
+	cfg, err := base.ConfigFile()
+	if err != nil {
+		return errdefs.Wrap(errdefs.KindOf(err), fmt.Errorf("getting config file of %s: %w", baseRef, err))
+	}
+
+	isCog := false
+	for k := range cfg.Config.Labels {
+		if strings.HasPrefix(k, "run.cog.") || strings.HasPrefix(k, "org.cogmodel.") {
+			isCog = true
+			break
+		}
+	}
+	if !isCog {
+		return errdefs.Errorf(errdefs.InvalidInput, "%s is not a cog image, it has no cog labels", baseRef)
+	}
+
+	src, err := GetSourceLayers(base, true, false)
+	if err != nil {
+		return err
+	}
+	if len(src) == 0 {
+		return errdefs.Errorf(errdefs.InvalidInput, "%s has no cog /src layer", baseRef)
+	}
+
+	return nil
+}
+
+// validateSchema checks that schema is a cog openapi schema with Input and
+// Output components
+func validateSchema(schema string) error {
+	var doc struct {
+		OpenAPI    string `json:"openapi"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal([]byte(schema), &doc); err != nil {
+		return err
+	}
+
+	if doc.OpenAPI == "" {
+		return fmt.Errorf("missing openapi version")
+	}
+	for _, name := range []string{"Input", "Output"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			return fmt.Errorf("missing components.schemas.%s", name)
+		}
+	}
+
+	return nil
+}
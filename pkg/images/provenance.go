@@ -0,0 +1,224 @@
+package images
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/replicate/yolo/pkg/errdefs"
+	"github.com/replicate/yolo/pkg/version"
+)
+
+const (
+	labelBase     = "run.yolo.base"
+	labelVersion  = "run.yolo.version"
+	labelCommit   = "run.yolo.commit"
+	labelCreated  = "run.yolo.created"
+	labelManifest = "run.yolo.manifest"
+)
+
+// Provenance records where a yolo image came from and what was changed
+type Provenance struct {
+	// Base is the digest reference of the upstream cog image
+	Base    string `json:"base"`
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	Created string `json:"created"`
+	// Manifest maps each file in the yolo layers to its sha256
+	Manifest map[string]string `json:"manifest,omitempty"`
+}
+
+func (p *Provenance) Files() []string {
+	var files []string
+	for f := range p.Manifest {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// ProvenanceOptions describes the image to read provenance from
+type ProvenanceOptions struct {
+	Config
+
+	Ref string
+}
+
+// ReadProvenance pulls o.Ref and returns its provenance, or a NotFound error
+// if it wasn't pushed by yolo
+func ReadProvenance(ctx context.Context, o ProvenanceOptions) (*Provenance, error) {
+	ctx = withConfig(ctx, o.Config)
+
+	img, err := pull(ctx, o.Ref, o.Session)
+	if err != nil {
+		return nil, fmt.Errorf("pulling %w", err)
+	}
+
+	p, err := GetProvenance(img)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, errdefs.Errorf(errdefs.NotFound, "no yolo provenance found on %s", o.Ref)
+	}
+	return p, nil
+}
+
+// returns nil if the image has no provenance labels
+func GetProvenance(img v1.Image) (*Provenance, error) {
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	labels := cfg.Config.Labels
+	if labels[labelBase] == "" {
+		return nil, nil
+	}
+
+	p := &Provenance{
+		Base:    labels[labelBase],
+		Version: labels[labelVersion],
+		Commit:  labels[labelCommit],
+		Created: labels[labelCreated],
+	}
+	if m := labels[labelManifest]; m != "" {
+		if err := json.Unmarshal([]byte(m), &p.Manifest); err != nil {
+			return nil, fmt.Errorf("parsing %s label: %w", labelManifest, err)
+		}
+	}
+
+	return p, nil
+}
+
+// newProvenance starts a record for an image built on top of base. If base
+// was itself produced by yolo, the original upstream base is carried forward.
+// A base with yolo layers but no provenance, e.g. from an older yolo or with
+// its labels stripped, isn't the upstream base, so Base is left empty.
+func newProvenance(ctx context.Context, baseRef string, base v1.Image, commit string) (*Provenance, error) {
+	prior, err := GetProvenance(base)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provenance{
+		Version: version.GetVersion(),
+		Commit:  commit,
+		Created: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if prior != nil {
+		p.Base = prior.Base
+		p.Manifest = prior.Manifest
+		if p.Commit == "" {
+			p.Commit = prior.Commit
+		}
+	} else {
+		yoloLayers, err := GetSourceLayers(base, false, true)
+		if err != nil {
+			return nil, err
+		}
+		if len(yoloLayers) > 0 {
+			progressFrom(ctx).Warn("%s has yolo layers but no provenance - the upstream base isn't recorded", baseRef)
+			return p, nil
+		}
+
+		p.Base, err = configFrom(ctx).ImageId(baseRef, base)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func setProvenance(img v1.Image, p *Provenance) (v1.Image, error) {
+	labels := map[string]string{
+		labelBase:    p.Base,
+		labelVersion: p.Version,
+		labelCommit:  p.Commit,
+		labelCreated: p.Created,
+	}
+
+	labels[labelManifest] = ""
+	if len(p.Manifest) > 0 {
+		m, err := json.Marshal(p.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		labels[labelManifest] = string(m)
+	}
+
+	return updateLabels(img, labels)
+}
+
+// removes all provenance labels from the image
+func clearProvenance(img v1.Image) (v1.Image, error) {
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	for k := range cfg.Config.Labels {
+		if strings.HasPrefix(k, "run.yolo.") {
+			delete(cfg.Config.Labels, k)
+		}
+	}
+
+	return mutate.Config(img, cfg.Config)
+}
+
+// hashLayers returns the sha256 of every regular file in the merged view of
+// the given layers, oldest layers first
+func hashLayers(layers []v1.Layer) (map[string]string, error) {
+	manifest := make(map[string]string)
+
+	for _, layer := range layers {
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return nil, err
+		}
+
+		err = hashTar(rc, manifest)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// hashTar adds the sha256 of every regular file in the tar stream to
+// manifest, replacing existing entries
+func hashTar(r io.Reader, manifest map[string]string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return err
+		}
+		manifest[header.Name] = hex.EncodeToString(h.Sum(nil))
+	}
+}
@@ -0,0 +1,79 @@
+package images
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/replicate/yolo/pkg/progress"
+)
+
+// TestProvenanceUnlabelledBase pushes on top of a yolo image whose labels were
+// stripped, which mustn't be recorded as the upstream base
+func TestProvenanceUnlabelledBase(t *testing.T) {
+	ctx := context.Background()
+
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+
+	c := testConfig()
+	c.Backend = NewMemory()
+	c.Progress = progress.New(devNull)
+
+	base := newCogBase(t, 1024, testFile("src/predict.py", []byte("print('hello')\n")))
+	if err := c.Backend.Write(ctx, parseRef(t, "r8.im/acme/base:v1"), base); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Yolo(ctx, YoloOptions{
+		Config:  c,
+		BaseRef: "r8.im/acme/base:v1",
+		Dest:    "r8.im/acme/model:v1",
+		Files:   []LayerFile{testFile("src/predict.py", []byte("print('hello, world')\n"))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warnings := c.Progress.Warnings(); len(warnings) != 0 {
+		t.Fatalf("got warnings %q pushing onto a cog base", warnings)
+	}
+
+	model, err := c.Backend.Image(ctx, parseRef(t, "r8.im/acme/model:v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlabelled, err := clearProvenance(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Backend.Write(ctx, parseRef(t, "r8.im/acme/unlabelled:v1"), unlabelled); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Yolo(ctx, YoloOptions{
+		Config:  c,
+		BaseRef: "r8.im/acme/unlabelled:v1",
+		Dest:    "r8.im/acme/model:v2",
+		Files:   []LayerFile{testFile("src/cog.yaml", []byte("predict: predict.py\n"))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pushed, err := c.Backend.Image(ctx, parseRef(t, "r8.im/acme/model:v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := pushed.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Config.Labels[labelBase]; got != "" {
+		t.Errorf("pushed image records base %s, want none", got)
+	}
+	if warnings := c.Progress.Warnings(); len(warnings) != 1 {
+		t.Errorf("got warnings %q, want one about the missing provenance", warnings)
+	}
+}
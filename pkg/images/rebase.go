@@ -0,0 +1,243 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/replicate/yolo/pkg/errdefs"
+)
+
+// labels that yolo itself manages and that are carried over even when we
+// can't diff against the original base
+var yoloLabels = []string{
+	"org.cogmodel.openapi_schema",
+	"run.cog.openapi_schema",
+	"org.opencontainers.image.revision",
+}
+
+// ConflictError lists files changed both by yolo and by the new base
+type ConflictError struct {
+	Files []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%d files changed in both the yolo layers and the new base: %s", len(e.Files), strings.Join(e.Files, ", "))
+}
+
+// RebaseOptions describes a rebase
+type RebaseOptions struct {
+	Config
+
+	// FromRef is the yolo image whose changes are moved
+	FromRef string
+	// OntoRef is the new base, a cog image without yolo layers
+	OntoRef string
+	Dest    string
+	// Force rebases even if the new base changed files yolo also changed
+	Force bool
+}
+
+// Rebase reapplies the yolo layers and config changes of o.FromRef on top of
+// o.OntoRef and returns the image id.  Unless o.Force is set, it refuses to
+// proceed when the /src layer of the new base changed files that yolo also
+// changed.
+func Rebase(ctx context.Context, o RebaseOptions) (string, error) {
+	ctx = withConfig(ctx, o.Config)
+	fromRef, ontoRef, dest, session := o.FromRef, o.OntoRef, o.Dest, o.Session
+
+	logFrom(ctx).Info("fetching metadata", "ref", fromRef)
+	from, err := pullBase(ctx, fromRef, session)
+	if err != nil {
+		return "", fmt.Errorf("pulling %w", err)
+	}
+
+	logFrom(ctx).Info("fetching metadata", "ref", ontoRef)
+	onto, err := pullBase(ctx, ontoRef, session)
+	if err != nil {
+		return "", fmt.Errorf("pulling %w", err)
+	}
+
+	ontoYolo, err := GetSourceLayers(onto, false, true)
+	if err != nil {
+		return "", fmt.Errorf("getting source layers: %w", err)
+	}
+	if len(ontoYolo) > 0 {
+		return "", errdefs.Errorf(errdefs.InvalidInput, "%s already has yolo layers, rebase onto a pristine cog image", ontoRef)
+	}
+
+	yoloLayers, err := GetSourceLayers(from, false, true)
+	if err != nil {
+		return "", fmt.Errorf("getting source layers: %w", err)
+	}
+	if len(yoloLayers) == 0 {
+		return "", errdefs.Errorf(errdefs.InvalidInput, "%s has no yolo layers to rebase", fromRef)
+	}
+
+	manifest, err := hashLayers(yoloLayers)
+	if err != nil {
+		return "", fmt.Errorf("hashing yolo layers: %w", err)
+	}
+
+	prov, err := GetProvenance(from)
+	if err != nil {
+		return "", fmt.Errorf("reading provenance: %w", err)
+	}
+
+	var orig v1.Image
+	if prov != nil {
+		logFrom(ctx).Info("fetching metadata for original base", "ref", prov.Base)
+		orig, err = pullBase(ctx, prov.Base, session)
+		if err != nil {
+			return "", fmt.Errorf("pulling original base %w", err)
+		}
+	} else {
+		progressFrom(ctx).Warn("no provenance on %s - only schema and commit labels are carried over and conflicts can't be detected", fromRef)
+	}
+
+	if orig != nil {
+		conflicts, err := findConflicts(orig, onto, manifest)
+		if err != nil {
+			return "", fmt.Errorf("checking for conflicts: %w", err)
+		}
+		if len(conflicts) > 0 {
+			cerr := &ConflictError{Files: conflicts}
+			if !o.Force {
+				return "", errdefs.Wrap(errdefs.Conflict, cerr)
+			}
+			progressFrom(ctx).Warn("%v", cerr)
+		}
+	}
+
+	img, err := applyConfigChanges(ctx, onto, orig, from)
+	if err != nil {
+		return "", fmt.Errorf("applying config changes: %w", err)
+	}
+
+	for _, layer := range yoloLayers {
+		img, err = mutate.Append(img, mutate.Addendum{Layer: layer, History: yoloHistory()})
+		if err != nil {
+			return "", fmt.Errorf("appending yolo layer: %w", err)
+		}
+	}
+
+	var commit string
+	if prov != nil {
+		commit = prov.Commit
+	}
+	p, err := newProvenance(ctx, ontoRef, onto, commit)
+	if err != nil {
+		return "", fmt.Errorf("reading provenance: %w", err)
+	}
+	p.Manifest = manifest
+
+	img, err = setProvenance(img, p)
+	if err != nil {
+		return "", fmt.Errorf("updating provenance: %w", err)
+	}
+
+	err = push(ctx, img, dest, session, mountSource{ontoRef, onto}, mountSource{fromRef, from})
+	if err != nil {
+		return "", fmt.Errorf("pushing %s: %w", dest, err)
+	}
+
+	return o.ImageId(dest, img)
+}
+
+// findConflicts returns the yolo files whose cog /src version differs
+// between the old and new base
+func findConflicts(oldBase v1.Image, newBase v1.Image, yoloFiles map[string]string) ([]string, error) {
+	oldLayers, err := GetSourceLayers(oldBase, true, false)
+	if err != nil {
+		return nil, err
+	}
+	oldSrc, err := hashLayers(oldLayers)
+	if err != nil {
+		return nil, err
+	}
+
+	newLayers, err := GetSourceLayers(newBase, true, false)
+	if err != nil {
+		return nil, err
+	}
+	newSrc, err := hashLayers(newLayers)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []string
+	for f := range yoloFiles {
+		if oldSrc[f] != newSrc[f] {
+			conflicts = append(conflicts, f)
+		}
+	}
+	sort.Strings(conflicts)
+
+	return conflicts, nil
+}
+
+// applyConfigChanges copies the env and label changes yolo made to from
+// (relative to orig) onto img. Without orig only yoloLabels are copied.
+func applyConfigChanges(ctx context.Context, img v1.Image, orig v1.Image, from v1.Image) (v1.Image, error) {
+	fromCfg, err := from.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]string)
+	var env []string
+
+	if orig == nil {
+		for _, k := range yoloLabels {
+			if v, ok := fromCfg.Config.Labels[k]; ok {
+				labels[k] = v
+			}
+		}
+	} else {
+		origCfg, err := orig.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range fromCfg.Config.Labels {
+			if strings.HasPrefix(k, "run.yolo.") {
+				continue
+			}
+			if ov, ok := origCfg.Config.Labels[k]; !ok || ov != v {
+				labels[k] = v
+			}
+		}
+		for k := range origCfg.Config.Labels {
+			if _, ok := fromCfg.Config.Labels[k]; !ok {
+				labels[k] = ""
+			}
+		}
+
+		origEnv := make(map[string]struct{})
+		for _, e := range origCfg.Config.Env {
+			origEnv[e] = struct{}{}
+		}
+		for _, e := range fromCfg.Config.Env {
+			if _, ok := origEnv[e]; !ok {
+				env = append(env, e)
+			}
+		}
+	}
+
+	img, err = updateLabels(img, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(env) > 0 {
+		img, err = updateEnv(ctx, img, env)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return img, nil
+}
@@ -0,0 +1,314 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dustin/go-humanize"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/replicate/yolo/pkg/errdefs"
+	"github.com/replicate/yolo/pkg/progress"
+	"golang.org/x/sync/errgroup"
+)
+
+// Mirror is a pull-through cache serving the same repositories as a registry
+type Mirror struct {
+	// Registry is the registry mirrored, the config's DefaultRegistry if
+	// empty
+	Registry string
+	// Host is the mirror, optionally with a path prefix like mirror:5000/r8
+	Host string
+}
+
+// ParseMirror parses [registry=]host[/prefix]
+func ParseMirror(s string) (Mirror, error) {
+	var m Mirror
+	if registry, host, found := strings.Cut(s, "="); found {
+		m.Registry = registry
+		s = host
+	}
+	m.Host = strings.TrimSuffix(s, "/")
+
+	if m.Host == "" {
+		return Mirror{}, errdefs.Errorf(errdefs.InvalidInput, "invalid registry mirror %q", s)
+	}
+	if _, err := name.NewRepository(m.Host + "/mirror"); err != nil {
+		return Mirror{}, errdefs.Errorf(errdefs.InvalidInput, "invalid registry mirror %q: %w", s, err)
+	}
+	return m, nil
+}
+
+func (m Mirror) String() string {
+	if m.Registry == "" {
+		return m.Host
+	}
+	return m.Registry + "=" + m.Host
+}
+
+// mirrors returns whether m mirrors repo's registry, where an empty
+// m.Registry stands for defaultRegistry
+func (m Mirror) mirrors(repo name.Repository, defaultRegistry string) bool {
+	registry := m.Registry
+	if registry == "" {
+		registry = defaultRegistry
+	}
+	return repo.RegistryStr() == registry
+}
+
+// reference returns ref as served by the mirror
+func (m Mirror) reference(ref name.Reference) (name.Reference, error) {
+	repo, err := name.NewRepository(m.Host + "/" + ref.Context().RepositoryStr())
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := ref.(name.Digest); ok {
+		return repo.Digest(d.DigestStr()), nil
+	}
+	return repo.Tag(ref.Identifier()), nil
+}
+
+// Registry is the default backend, talking to image registries.  Pulls go
+// through Mirrors first; digests are always read from the registry itself
+// so tags and --if-match see the latest push.
+type Registry struct {
+	Session authn.Keychain
+	Mirrors []Mirror
+}
+
+func (r *Registry) Image(ctx context.Context, ref name.Reference) (v1.Image, error) {
+	for _, m := range r.Mirrors {
+		if !m.mirrors(ref.Context(), configFrom(ctx).registry()) {
+			continue
+		}
+		mref, err := m.reference(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		img, err := remote.Image(mref, remoteOptions(ctx, r.Session)...)
+		if err == nil {
+			logFrom(ctx).Debug("pulling through mirror", "ref", ref.Name(), "mirror", mref.Name())
+			return img, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logFrom(ctx).Warn("mirror failed, pulling from the registry", "mirror", m.Host, "error", err)
+	}
+
+	var img v1.Image
+	err := withRetry(ctx, "pulling "+ref.Name(), func() error {
+		var err error
+		img, err = remote.Image(ref, remoteOptions(ctx, r.Session)...)
+		return err
+	})
+	return img, err
+}
+
+func (r *Registry) Digest(ctx context.Context, ref name.Reference) (v1.Hash, error) {
+	var d string
+	err := withRetry(ctx, "getting digest of "+ref.Name(), func() error {
+		var err error
+		d, err = crane.Digest(ref.Name(), craneOptions(ctx, r.Session)...)
+		return err
+	})
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	return v1.NewHash(d)
+}
+
+// Write uploads each layer with its own progress bar, then the config and
+// the manifest.  Layers of the images pushes pass with withMountFrom are
+// mounted from their repositories where the registry allows it, and
+// uploaded otherwise.  Failed pushes are retried; layers that already
+// reached the registry are skipped on the next attempt, but a layer cut off
+// midway is uploaded again from its start.
+func (r *Registry) Write(ctx context.Context, ref name.Reference, img v1.Image) error {
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	config, err := partial.ConfigLayer(img)
+	if err != nil {
+		return err
+	}
+	manifest, err := img.RawManifest()
+	if err != nil {
+		return err
+	}
+	mediaType, err := img.MediaType()
+	if err != nil {
+		return err
+	}
+
+	// kept across attempts, so a retry only goes over the layers that
+	// haven't landed yet and reports each layer once
+	uploads := make(map[v1.Hash]*layerUpload)
+	// the upload phase spans every attempt, waits included
+	endUpload := sync.OnceFunc(progressFrom(ctx).Phase("upload"))
+	defer endUpload()
+
+	err = withRetry(ctx, "pushing "+ref.Name(), func() error {
+		if err := r.uploadLayers(ctx, ref.Context(), layers, uploads); err != nil {
+			return err
+		}
+		endUpload()
+
+		defer progressFrom(ctx).Phase("manifest")()
+		if err := remote.WriteLayer(ref.Context(), config, remoteOptions(ctx, r.Session)...); err != nil {
+			return err
+		}
+		// every blob was just written, remote.Write would check them again
+		return remote.Put(ref, rawManifest{manifest, mediaType}, remoteOptions(ctx, r.Session)...)
+	})
+	if err != nil {
+		return err
+	}
+
+	var mounted, transferred int64
+	for _, u := range uploads {
+		if u.mounted {
+			mounted += u.size
+		}
+		transferred += u.sent.Load()
+	}
+	logFrom(ctx).Info("pushed layers", "mounted", humanize.Bytes(uint64(mounted)), "transferred", humanize.Bytes(uint64(transferred)))
+	return nil
+}
+
+// layerUpload is the state of one layer across the attempts of a push
+type layerUpload struct {
+	bar  *progress.Bar
+	size int64
+	// sent is how many bytes the latest attempt read from the layer
+	sent    atomic.Int64
+	done    bool
+	mounted bool
+}
+
+// CheckPush fails if the session can't push to ref
+func (r *Registry) CheckPush(ctx context.Context, ref name.Reference) error {
+	if err := remote.CheckPushPermission(ref, r.Session, transport(ctx)); err != nil {
+		return errdefs.Wrap(errdefs.KindOf(err), fmt.Errorf("can't push to %s, check that the model exists and you can write to it: %w", ref.Name(), err))
+	}
+	return nil
+}
+
+// uploadLayers uploads the layers that uploads doesn't have as done yet
+func (r *Registry) uploadLayers(ctx context.Context, repo name.Repository, layers []v1.Layer, uploads map[v1.Hash]*layerUpload) error {
+	// each goroutine only touches the entry of its own layer, so a layer
+	// listed twice is uploaded once
+	var pending []v1.Layer
+	seen := make(map[v1.Hash]bool)
+	for _, layer := range layers {
+		d, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		if seen[d] {
+			continue
+		}
+		seen[d] = true
+
+		u, ok := uploads[d]
+		if !ok {
+			size, err := layer.Size()
+			if err != nil {
+				return err
+			}
+			u = &layerUpload{size: size}
+			uploads[d] = u
+		}
+		// layers that landed in an earlier attempt aren't checked again
+		if !u.done {
+			pending = append(pending, layer)
+		}
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(configFrom(ctx).jobs())
+
+	for _, layer := range pending {
+		layer := layer
+		g.Go(func() error {
+			d, err := layer.Digest()
+			if err != nil {
+				return err
+			}
+			u := uploads[d]
+
+			bar := u.bar
+			if bar == nil {
+				bar = progressFrom(ctx).Start(shortDigest(d), u.size)
+				u.bar = bar
+			} else {
+				bar.Retry()
+			}
+			u.sent.Store(0)
+			var l v1.Layer = countingLayer{Layer: layer, add: func(n int64) {
+				u.sent.Add(n)
+				bar.Add(n)
+			}}
+			src, mountable := mountFrom(ctx, repo, d)
+			if mountable {
+				// the mount request also starts the upload if the
+				// registry refuses it, so no upload is left open
+				l = &remote.MountableLayer{Layer: l, Reference: src.Digest(d.String())}
+			}
+
+			w := &blobWatcher{inner: transport(ctx)}
+			err = remote.WriteLayer(repo, l, append(remoteOptions(ctx, r.Session), remote.WithTransport(w))...)
+			switch {
+			case err != nil:
+				bar.Fail(err)
+				return err
+			case w.existed.Load():
+				logFrom(ctx).Debug("already exists", "layer", shortDigest(d))
+				bar.Skip()
+			case w.mounted.Load():
+				logFrom(ctx).Debug("mounted", "layer", shortDigest(d), "from", src.Name())
+				u.mounted = true
+				bar.Mount()
+			default:
+				bar.Done()
+			}
+			u.done = true
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// rawManifest is an image's manifest on its own, which remote.Put puts
+// without writing the blobs it refers to
+type rawManifest struct {
+	raw       []byte
+	mediaType types.MediaType
+}
+
+func (m rawManifest) RawManifest() ([]byte, error) {
+	return m.raw, nil
+}
+
+func (m rawManifest) MediaType() (types.MediaType, error) {
+	return m.mediaType, nil
+}
+
+func shortDigest(d v1.Hash) string {
+	hex := d.Hex
+	if len(hex) > 12 {
+		hex = hex[:12]
+	}
+	return d.Algorithm + ":" + hex
+}
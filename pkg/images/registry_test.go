@@ -0,0 +1,483 @@
+package images
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/replicate/yolo/pkg/progress"
+)
+
+// newTestRegistry starts an in-memory registry and returns its host
+func newTestRegistry(tb testing.TB) string {
+	s := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	tb.Cleanup(s.Close)
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// testConfig is a Config that doesn't log
+func testConfig() Config {
+	return Config{Log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+}
+
+func testFile(path string, body []byte) LayerFile {
+	return LayerFile{
+		Header: &tar.Header{Name: path, Mode: 0644, Size: int64(len(body))},
+		Body:   body,
+	}
+}
+
+func randomBytes(tb testing.TB, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		tb.Fatal(err)
+	}
+	return b
+}
+
+// newCogBase returns a cog image with a random system layer of size bytes
+// and a /src layer holding files
+func newCogBase(tb testing.TB, size int64, files ...LayerFile) v1.Image {
+	system, err := random.Layer(size, types.DockerLayer)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	buf, err := MakeTar(context.Background(), files, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	data := buf.Bytes()
+	src, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	img, err := mutate.Append(empty.Image,
+		mutate.Addendum{Layer: system, History: v1.History{CreatedBy: "RUN pip install -r requirements.txt"}},
+		mutate.Addendum{Layer: src, History: v1.History{CreatedBy: "COPY . /src # buildkit"}},
+	)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	img, err = mutate.Config(img, v1.Config{Labels: map[string]string{"run.cog.version": "0.9.0"}})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return img
+}
+
+func parseRef(tb testing.TB, ref string) name.Reference {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return r
+}
+
+// writeTestImage pushes img to ref on a test registry
+func writeTestImage(tb testing.TB, ref string, img v1.Image) {
+	if err := remote.Write(parseRef(tb, ref), img); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// readTree returns the content of every file under dir, by relative path
+func readTree(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestPushCloneFetch(t *testing.T) {
+	ctx := context.Background()
+	host := newTestRegistry(t)
+	other := newTestRegistry(t)
+
+	baseRef := host + "/acme/base:v1"
+	base := newCogBase(t, 1024,
+		testFile("src/predict.py", []byte("print('hello')\n")),
+		testFile("src/cog.yaml", []byte("predict: predict.py\n")),
+	)
+	writeTestImage(t, baseRef, base)
+	baseLayers, err := base.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Yolo(ctx, YoloOptions{
+		Config:  testConfig(),
+		BaseRef: baseRef,
+		Dest:    host + "/acme/model:v1",
+		Files: []LayerFile{
+			testFile("src/predict.py", []byte("print('hello, world')\n")),
+			testFile("src/cog.yaml", []byte("predict: predict.py\n")),
+		},
+		Commit: "abc123",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Files != 1 || result.Unchanged != 1 {
+		t.Errorf("push added %d files and skipped %d, want 1 and 1", result.Files, result.Unchanged)
+	}
+
+	pushed, err := remote.Image(parseRef(t, result.ImageId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := pushed.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != len(baseLayers)+1 {
+		t.Errorf("pushed image has %d layers, want %d", len(layers), len(baseLayers)+1)
+	}
+	prov, err := GetProvenance(pushed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prov == nil || prov.Commit != "abc123" {
+		t.Errorf("pushed image has provenance %+v, want commit abc123", prov)
+	}
+
+	t.Run("clone", func(t *testing.T) {
+		dests := []string{host + "/acme/clone:v1", other + "/acme/clone:v1"}
+		ids, err := Clone(ctx, CloneOptions{
+			Config:  testConfig(),
+			BaseRef: result.ImageId,
+			Dests:   dests,
+			Marker:  MarkerNever,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, id := range ids {
+			got, err := remote.Head(parseRef(t, dests[i]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Digest.String() != result.Digest {
+				t.Errorf("%s has digest %s, want %s", dests[i], got.Digest, result.Digest)
+			}
+			if !strings.HasSuffix(id, "@"+result.Digest) {
+				t.Errorf("clone to %s returned %s, want digest %s", dests[i], id, result.Digest)
+			}
+		}
+	})
+
+	t.Run("fetch", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "src")
+		err := Extract(ctx, ExtractOptions{
+			Config:  testConfig(),
+			BaseRef: result.ImageId,
+			Dest:    dir,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := readTree(t, dir)
+		want := map[string]string{
+			"src/predict.py": "print('hello, world')\n",
+			"src/cog.yaml":   "predict: predict.py\n",
+		}
+		if len(got) != len(want) {
+			t.Errorf("fetched %v, want %v", got, want)
+		}
+		for path, body := range want {
+			if got[path] != body {
+				t.Errorf("fetched %s is %q, want %q", path, got[path], body)
+			}
+		}
+	})
+}
+
+// TestWriteRetry fails the upload of the second layer once, and checks that
+// the retry only uploads what didn't land and lists every layer once
+func TestWriteRetry(t *testing.T) {
+	ctx := context.Background()
+
+	var puts atomic.Int32
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/blobs/uploads/") && puts.Add(1) == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	img, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+
+	c := testConfig()
+	c.Jobs = 1
+	c.Retries = 1
+	c.RetryBackoff = time.Millisecond
+	c.Progress = progress.New(devNull)
+	ref := parseRef(t, strings.TrimPrefix(s.URL, "http://")+"/acme/model:v1")
+	if err := (&Registry{}).Write(withConfig(ctx, c), ref, img); err != nil {
+		t.Fatal(err)
+	}
+
+	if warnings := c.Progress.Warnings(); len(warnings) != 1 {
+		t.Errorf("got warnings %q, want one retry", warnings)
+	}
+	transfers := c.Progress.Transfers()
+	if len(transfers) != 3 {
+		t.Fatalf("got %d transfers, want one per layer: %+v", len(transfers), transfers)
+	}
+	seen := make(map[string]bool)
+	for _, tr := range transfers {
+		if seen[tr.Name] {
+			t.Errorf("layer %s is listed twice", tr.Name)
+		}
+		seen[tr.Name] = true
+		if tr.Skipped || tr.Mounted || tr.Sent != tr.Size {
+			t.Errorf("layer %s has %+v, want it uploaded once", tr.Name, tr)
+		}
+	}
+}
+
+func TestFetchRetry(t *testing.T) {
+	ctx := context.Background()
+
+	base := newCogBase(t, 1024, testFile("src/predict.py", []byte("print('hello')\n")))
+	src, err := GetSourceLayers(base, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := src[0].Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the only /src layer fails once
+	var gets atomic.Int32
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/blobs/"+digest.String()) && gets.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	ref := strings.TrimPrefix(s.URL, "http://") + "/acme/base:v1"
+	writeTestImage(t, ref, base)
+
+	c := testConfig()
+	c.Retries = 1
+	c.RetryBackoff = time.Millisecond
+	dest := filepath.Join(t.TempDir(), "src")
+	err = Extract(ctx, ExtractOptions{
+		Config:  c,
+		BaseRef: ref,
+		Dest:    dest,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readTree(t, dest)["src/predict.py"]; got != "print('hello')\n" {
+		t.Errorf("fetched src/predict.py is %q", got)
+	}
+}
+
+// benchJobs are the --jobs the benchmarks compare
+var benchJobs = []int{1, 4, 8}
+
+// benchLatency is added to every request of a bench registry, like a
+// registry a few milliseconds away; without it parallel transfers of an
+// in-process registry gain little
+const benchLatency = 5 * time.Millisecond
+
+// newBenchRegistry starts a test registry that answers after benchLatency
+func newBenchRegistry(tb testing.TB) string {
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(benchLatency)
+		reg.ServeHTTP(w, r)
+	}))
+	tb.Cleanup(s.Close)
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// addLayers appends n random layers of size bytes to img
+func addLayers(tb testing.TB, img v1.Image, n int, size int64) v1.Image {
+	for i := 0; i < n; i++ {
+		layer, err := random.Layer(size, types.DockerLayer)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		img, err = mutate.Append(img, mutate.Addendum{Layer: layer, History: v1.History{CreatedBy: fmt.Sprintf("RUN step %d", i)}})
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return img
+}
+
+// BenchmarkPush pushes a file onto a base of 8 layers kept in another
+// registry, so every layer is uploaded
+func BenchmarkPush(b *testing.B) {
+	ctx := context.Background()
+	from := newBenchRegistry(b)
+	baseRef := from + "/acme/base:v1"
+	base := addLayers(b, newCogBase(b, 1<<20, testFile("src/predict.py", []byte("print('hello')\n"))), 8, 2<<20)
+	writeTestImage(b, baseRef, base)
+
+	for _, jobs := range benchJobs {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			c := testConfig()
+			c.Jobs = jobs
+			b.SetBytes(17 << 20)
+
+			for i := 0; i < b.N; i++ {
+				// a new registry every time, so no blob is already there
+				b.StopTimer()
+				to := newBenchRegistry(b)
+				b.StartTimer()
+
+				_, err := Yolo(ctx, YoloOptions{
+					Config:  c,
+					BaseRef: baseRef,
+					Dest:    to + "/acme/model",
+					Files:   []LayerFile{testFile("src/predict.py", []byte("print('hello, world')\n"))},
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFetch fetches an image with 8 /src layers
+func BenchmarkFetch(b *testing.B) {
+	ctx := context.Background()
+	host := newBenchRegistry(b)
+	baseRef := host + "/acme/base:v1"
+	writeTestImage(b, baseRef, newCogBase(b, 1<<20, testFile("src/predict.py", randomBytes(b, 2<<20))))
+
+	// stacked, so there are several /src layers to download at once
+	ref := baseRef
+	for i := 0; i < 7; i++ {
+		_, err := Yolo(ctx, YoloOptions{
+			Config:  testConfig(),
+			BaseRef: ref,
+			Dest:    host + "/acme/model:v1",
+			Files:   []LayerFile{testFile(fmt.Sprintf("src/layer%d.bin", i), randomBytes(b, 2<<20))},
+			Stack:   true,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		ref = host + "/acme/model:v1"
+	}
+
+	for _, jobs := range benchJobs {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			c := testConfig()
+			c.Jobs = jobs
+			dir := b.TempDir()
+			b.SetBytes(16 << 20)
+
+			for i := 0; i < b.N; i++ {
+				err := Extract(ctx, ExtractOptions{
+					Config:  c,
+					BaseRef: ref,
+					Dest:    filepath.Join(dir, fmt.Sprint(i)),
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCopy copies an image of 8 layers to another registry
+func BenchmarkCopy(b *testing.B) {
+	ctx := context.Background()
+	from := newBenchRegistry(b) + "/acme/base:v1"
+	base := addLayers(b, newCogBase(b, 1<<20, testFile("src/predict.py", []byte("print('hello')\n"))), 8, 2<<20)
+	writeTestImage(b, from, base)
+
+	for _, jobs := range benchJobs {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			c := testConfig()
+			c.Jobs = jobs
+			b.SetBytes(17 << 20)
+
+			for i := 0; i < b.N; i++ {
+				// a new registry every time, so no blob is already there
+				b.StopTimer()
+				to := newBenchRegistry(b)
+				b.StartTimer()
+
+				_, err := Copy(ctx, CopyOptions{
+					Config: c,
+					From:   from,
+					To:     to + "/acme/copy:v1",
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package images
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// ResetOptions describes a reset
+type ResetOptions struct {
+	Config
+
+	// BaseRef is the yolo image to reset
+	BaseRef string
+	Dest    string
+}
+
+// Reset strips all yolo layers from o.BaseRef and returns the image id.
+// When the image has provenance, the env and labels of the original base are
+// restored as well.
+func Reset(ctx context.Context, o ResetOptions) (string, error) {
+	ctx = withConfig(ctx, o.Config)
+	baseRef, dest, session := o.BaseRef, o.Dest, o.Session
+
+	logFrom(ctx).Info("fetching metadata", "ref", baseRef)
+	base, err := pull(ctx, baseRef, session)
+	if err != nil {
+		return "", fmt.Errorf("pulling %w", err)
+	}
+
+	img, err := removeYolo(ctx, base)
+	if err != nil {
+		return "", fmt.Errorf("removing existing yolo layers: %w", err)
+	}
+
+	img, err = restoreOriginal(ctx, img, base, baseRef, session)
+	if err != nil {
+		return "", err
+	}
+
+	err = push(ctx, img, dest, session, mountSource{baseRef, base})
+	if err != nil {
+		return "", fmt.Errorf("pushing %s: %w", dest, err)
+	}
+
+	return o.ImageId(dest, img)
+}
+
+// restoreOriginal restores the env and labels of the image base was pushed
+// from onto img, when base has provenance.  Otherwise only the provenance
+// labels are removed.
+func restoreOriginal(ctx context.Context, img v1.Image, base v1.Image, baseRef string, session authn.Keychain) (v1.Image, error) {
+	prov, err := GetProvenance(base)
+	if err != nil {
+		return nil, fmt.Errorf("reading provenance: %w", err)
+	}
+
+	if prov == nil {
+		progressFrom(ctx).Warn("no provenance on %s - schema, env and labels are left as they are", baseRef)
+
+		img, err = clearProvenance(img)
+		if err != nil {
+			return nil, fmt.Errorf("removing provenance: %w", err)
+		}
+		return img, nil
+	}
+
+	logFrom(ctx).Info("fetching metadata for original base", "ref", prov.Base)
+	orig, err := pull(ctx, prov.Base, session)
+	if err != nil {
+		return nil, fmt.Errorf("pulling original base %w", err)
+	}
+
+	img, err = restoreConfig(img, orig)
+	if err != nil {
+		return nil, fmt.Errorf("restoring config: %w", err)
+	}
+	return img, nil
+}
+
+// restoreConfig replaces the env and labels of img with those of orig
+func restoreConfig(img v1.Image, orig v1.Image) (v1.Image, error) {
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	origCfg, err := orig.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Config.Env = append([]string(nil), origCfg.Config.Env...)
+	cfg.Config.Labels = make(map[string]string)
+	for k, v := range origCfg.Config.Labels {
+		cfg.Config.Labels[k] = v
+	}
+
+	return mutate.Config(img, cfg.Config)
+}
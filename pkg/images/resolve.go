@@ -0,0 +1,197 @@
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/replicate/yolo/pkg/errdefs"
+	"github.com/replicate/yolo/pkg/logging"
+)
+
+type modelResponse struct {
+	LatestVersion *struct {
+		Id string `json:"id"`
+	} `json:"latest_version"`
+}
+
+type versionsResponse struct {
+	Next    string `json:"next"`
+	Results []struct {
+		Id string `json:"id"`
+	} `json:"results"`
+}
+
+// ResolveBase returns the fully qualified form of baseRef.  A Replicate model
+// without a tag or digest (owner/model) is resolved through the models API to
+// the digest of its latest version; version ids are already digests.  A tag
+// that is the start of one of the model's version ids, like owner/model:1bfb924,
+// is resolved to that version, and is left as a tag if it matches none.
+func (c Config) ResolveBase(ctx context.Context, baseRef string, token string) (string, error) {
+	ctx = withConfig(ctx, c)
+
+	ref, err := c.ParseReference(baseRef)
+	if err != nil {
+		return "", err
+	}
+
+	tag, ok := ref.(name.Tag)
+	if !ok || !isReplicate(tag.Context()) {
+		return ref.Name(), nil
+	}
+
+	var versionId string
+	switch {
+	case tag.TagStr() == name.DefaultTag:
+		versionId, err = latestVersion(ctx, tag.RepositoryStr(), token)
+	case shortVersionIdPattern.MatchString(tag.TagStr()):
+		versionId, err = versionWithPrefix(ctx, tag.RepositoryStr(), tag.TagStr(), token)
+	default:
+		return ref.Name(), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", baseRef, err)
+	}
+	if versionId == "" {
+		return ref.Name(), nil
+	}
+
+	resolved := tag.Context().Digest("sha256:" + versionId).Name()
+	logFrom(ctx).Info("resolved", "ref", baseRef, "version", resolved)
+
+	return resolved, nil
+}
+
+func latestVersion(ctx context.Context, model string, token string) (string, error) {
+	body := &modelResponse{}
+	if err := getModel(ctx, modelURL(ctx, model), model, token, body); err != nil {
+		return "", err
+	}
+	if body.LatestVersion == nil || body.LatestVersion.Id == "" {
+		return "", errdefs.Errorf(errdefs.NotFound, "model %s has no versions", model)
+	}
+	if !versionIdPattern.MatchString(body.LatestVersion.Id) {
+		return "", fmt.Errorf("unexpected version id %q", body.LatestVersion.Id)
+	}
+
+	return body.LatestVersion.Id, nil
+}
+
+// maxVersionPages caps how many pages of a model's versions are looked
+// through for a short version id
+const maxVersionPages = 50
+
+// versionWithPrefix returns the version of model whose id starts with
+// prefix, or "" if there is none so that prefix is used as a plain tag
+func versionWithPrefix(ctx context.Context, model string, prefix string, token string) (string, error) {
+	var matches []string
+	incomplete := func(err error) (string, error) {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		// a partial list can't tell a unique match from an ambiguous one
+		if len(matches) > 0 {
+			return "", fmt.Errorf("looking up versions: %w", err)
+		}
+		// it may well be a real tag, let the registry decide
+		logFrom(ctx).Warn("couldn't look up versions, using it as a tag", "model", model, "tag", prefix, "error", err)
+		return "", nil
+	}
+
+	page := modelURL(ctx, model) + "/versions"
+	for n := 0; page != ""; n++ {
+		if n == maxVersionPages {
+			return incomplete(fmt.Errorf("%s has more than %d pages of versions", model, maxVersionPages))
+		}
+
+		body := &versionsResponse{}
+		if err := getModel(ctx, page, model, token, body); err != nil {
+			return incomplete(err)
+		}
+		for _, v := range body.Results {
+			if strings.HasPrefix(v.Id, prefix) && versionIdPattern.MatchString(v.Id) {
+				matches = append(matches, v.Id)
+			}
+		}
+
+		var err error
+		page, err = nextPage(ctx, page, body.Next)
+		if err != nil {
+			return incomplete(err)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		logFrom(ctx).Debug("no version matches, using it as a tag", "model", model, "tag", prefix)
+		return "", nil
+	case 1:
+		return matches[0], nil
+	}
+	return "", errdefs.Errorf(errdefs.InvalidInput, "ambiguous version id %s: %d versions of %s start with it, use more characters", prefix, len(matches), model)
+}
+
+// nextPage resolves the next link of a page of results against its url. The
+// API token is sent with every page, so only links to the API itself are
+// followed.
+func nextPage(ctx context.Context, page string, next string) (string, error) {
+	if next == "" {
+		return "", nil
+	}
+
+	api, err := url.Parse(configFrom(ctx).apiBaseURL())
+	if err != nil {
+		return "", err
+	}
+	current, err := url.Parse(page)
+	if err != nil {
+		return "", err
+	}
+	u, err := current.Parse(next)
+	if err != nil {
+		return "", fmt.Errorf("parsing next page: %w", err)
+	}
+	if u.Scheme != api.Scheme || u.Host != api.Host {
+		return "", fmt.Errorf("next page %s isn't on %s://%s", logging.RedactURL(u), api.Scheme, api.Host)
+	}
+
+	return u.String(), nil
+}
+
+func modelURL(ctx context.Context, model string) string {
+	return fmt.Sprintf("%s/v1/models/%s", strings.TrimSuffix(configFrom(ctx).apiBaseURL(), "/"), model)
+}
+
+// getModel decodes a models API response from url into v
+func getModel(ctx context.Context, url string, model string, token string, v any) error {
+	if strings.Count(model, "/") != 1 {
+		return errdefs.Errorf(errdefs.InvalidInput, "expected owner/model, got %s", model)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Token %s", token))
+	}
+
+	resp, err := (&http.Client{Transport: transport(ctx)}).Do(req)
+	if err != nil {
+		return errdefs.Wrap(errdefs.Retryable, fmt.Errorf("failed to get model: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errdefs.Errorf(errdefs.NotFound, "model %s does not exist", model)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errdefs.FromStatus(resp.StatusCode, fmt.Errorf("failed to get model, got status %d", resp.StatusCode))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
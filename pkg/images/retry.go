@@ -0,0 +1,59 @@
+package images
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/replicate/yolo/pkg/errdefs"
+)
+
+// withRetry calls fn until it succeeds, fails with an error that isn't
+// retryable, or runs out of retries.  It is the only retry policy: ggcr's
+// own retries are turned off in remoteOptions, so a flaky registry isn't
+// retried Retries times over for every request, and each attempt shows up
+// in the progress output and the warnings of --output json.
+func withRetry(ctx context.Context, what string, fn func() error) error {
+	c := configFrom(ctx)
+	delay := c.retryBackoff()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || ctx.Err() != nil || attempt > c.Retries || errdefs.KindOf(err) != errdefs.Retryable {
+			return err
+		}
+
+		progressFrom(ctx).Warn("%s failed, retrying in %s (attempt %d/%d): %v", what, delay, attempt+1, c.Retries+1, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func remoteOptions(ctx context.Context, session authn.Keychain) []remote.Option {
+	c := configFrom(ctx)
+	return []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(session),
+		remote.WithTransport(transport(ctx)),
+		remote.WithJobs(c.jobs()),
+		// withRetry retries instead.  ggcr's transport still redials a
+		// dropped connection a few times within a second, which can't be
+		// turned off without losing its auth handshake.
+		remote.WithRetryBackoff(remote.Backoff{Steps: 1}),
+		remote.WithRetryStatusCodes(),
+	}
+}
+
+func craneOptions(ctx context.Context, session authn.Keychain) []crane.Option {
+	return []crane.Option{
+		crane.WithAuthFromKeychain(session),
+		func(o *crane.Options) {
+			o.Remote = append(o.Remote, remoteOptions(ctx, session)...)
+		},
+	}
+}
@@ -0,0 +1,77 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/replicate/yolo/pkg/errdefs"
+)
+
+// SquashOptions describes a squash
+type SquashOptions struct {
+	Config
+
+	// BaseRef is the image whose stacked yolo layers are collapsed
+	BaseRef string
+	Dest    string
+}
+
+// Squash collapses all yolo layers of o.BaseRef into a single layer and
+// returns the image id
+func Squash(ctx context.Context, o SquashOptions) (string, error) {
+	ctx = withConfig(ctx, o.Config)
+	baseRef, dest, session := o.BaseRef, o.Dest, o.Session
+
+	logFrom(ctx).Info("fetching metadata", "ref", baseRef)
+	base, err := pullBase(ctx, baseRef, session)
+	if err != nil {
+		return "", fmt.Errorf("pulling %w", err)
+	}
+
+	yoloLayers, err := GetSourceLayers(base, false, true)
+	if err != nil {
+		return "", fmt.Errorf("getting source layers: %w", err)
+	}
+	if len(yoloLayers) == 0 {
+		return "", errdefs.Errorf(errdefs.InvalidInput, "%s has no yolo layers to squash", baseRef)
+	}
+
+	yoloLess, err := removeYolo(ctx, base)
+	if err != nil {
+		return "", fmt.Errorf("removing existing yolo layers: %w", err)
+	}
+
+	logFrom(ctx).Info("squashing yolo layers", "count", len(yoloLayers))
+
+	newLayer, err := MakeTar(ctx, nil, yoloLayers)
+	if err != nil {
+		return "", fmt.Errorf("making tar: %w", err)
+	}
+
+	prov, err := newProvenance(ctx, baseRef, base, "")
+	if err != nil {
+		return "", fmt.Errorf("reading provenance: %w", err)
+	}
+	prov.Manifest = make(map[string]string)
+	if err := hashTar(bytes.NewReader(newLayer.Bytes()), prov.Manifest); err != nil {
+		return "", fmt.Errorf("hashing layer: %w", err)
+	}
+
+	img, err := appendLayer(yoloLess, newLayer)
+	if err != nil {
+		return "", fmt.Errorf("appending %v: %w", newLayer, err)
+	}
+
+	img, err = setProvenance(img, prov)
+	if err != nil {
+		return "", fmt.Errorf("updating provenance: %w", err)
+	}
+
+	err = push(ctx, img, dest, session, mountSource{baseRef, base})
+	if err != nil {
+		return "", fmt.Errorf("pushing %s: %w", dest, err)
+	}
+
+	return o.ImageId(dest, img)
+}
@@ -1,6 +1,14 @@
 package images
 
-import v1 "github.com/google/go-containerregistry/pkg/v1"
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
 
 // returns /src layers created by yolo and/or cog, oldest layers first
 func GetSourceLayers(base v1.Image, cog bool, yolo bool) ([]v1.Layer, error) {
@@ -33,3 +41,91 @@ func GetSourceLayers(base v1.Image, cog bool, yolo bool) ([]v1.Layer, error) {
 
 	return srcLayers, nil
 }
+
+// skipUnchanged drops files that are byte-identical to the merged /src view
+// of base, so the yolo layer only contains real modifications.  Only base
+// files with the same name and size as one of files are hashed.
+func skipUnchanged(ctx context.Context, base v1.Image, files []LayerFile) ([]LayerFile, error) {
+	if len(files) == 0 {
+		return files, nil
+	}
+
+	srcLayers, err := GetSourceLayers(base, true, true)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64, len(files))
+	for _, file := range files {
+		sizes[file.Header.Name] = int64(len(file.Body))
+	}
+	existing, err := hashMatching(srcLayers, sizes)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []LayerFile
+	skipped := 0
+	for _, file := range files {
+		if want := existing[file.Header.Name]; want != "" && want == sha256Hex(file.Body) {
+			logFrom(ctx).Debug("unchanged", "file", file.Header.Name)
+			skipped++
+			continue
+		}
+		changed = append(changed, file)
+	}
+
+	logFrom(ctx).Info("skipped unchanged files", "skipped", skipped, "adding", len(changed))
+
+	return changed, nil
+}
+
+// hashMatching returns the sha256 of the regular files in layers that have
+// a name and size in sizes, the latest layer winning.  Files of another size
+// are recorded with no hash, since they can't be unchanged.
+func hashMatching(layers []v1.Layer, sizes map[string]int64) (map[string]string, error) {
+	hashes := make(map[string]string)
+
+	for _, layer := range layers {
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return nil, err
+		}
+
+		tr := tar.NewReader(rc)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				rc.Close()
+				return nil, err
+			}
+
+			size, ok := sizes[header.Name]
+			if !ok || header.Typeflag != tar.TypeReg {
+				continue
+			}
+			if header.Size != size {
+				hashes[header.Name] = ""
+				continue
+			}
+
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				rc.Close()
+				return nil, err
+			}
+			hashes[header.Name] = hex.EncodeToString(h.Sum(nil))
+		}
+		rc.Close()
+	}
+
+	return hashes, nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,44 @@
+package images
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSkipUnchanged(t *testing.T) {
+	base := newCogBase(t, 1024,
+		testFile("src/predict.py", []byte("print('hello')\n")),
+		testFile("src/cog.yaml", []byte("predict: predict.py\n")),
+		testFile("src/weights.txt", []byte("0123456789")),
+	)
+
+	files := []LayerFile{
+		// unchanged
+		testFile("src/predict.py", []byte("print('hello')\n")),
+		// same size, different content
+		testFile("src/weights.txt", []byte("9876543210")),
+		// different size
+		testFile("src/cog.yaml", []byte("predict: predict.py:Predictor\n")),
+		// new
+		testFile("src/util.py", []byte("pass\n")),
+	}
+
+	changed, err := skipUnchanged(context.Background(), base, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, f := range changed {
+		got = append(got, f.Header.Name)
+	}
+	want := []string{"src/weights.txt", "src/cog.yaml", "src/util.py"}
+	if len(got) != len(want) {
+		t.Fatalf("changed files are %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("changed files are %v, want %v", got, want)
+		}
+	}
+}
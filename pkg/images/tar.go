@@ -3,9 +3,8 @@ package images
 import (
 	"archive/tar"
 	"bytes"
-	"fmt"
+	"context"
 	"io"
-	"os"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
@@ -15,14 +14,16 @@ type LayerFile struct {
 	Body   []byte
 }
 
-func MakeTar(files []LayerFile, layers []v1.Layer) (*bytes.Buffer, error) {
+func MakeTar(ctx context.Context, files []LayerFile, layers []v1.Layer) (*bytes.Buffer, error) {
+	defer progressFrom(ctx).Phase("build layer")()
+
 	added := make(map[string]struct{})
 
 	buf := new(bytes.Buffer)
 	tw := tar.NewWriter(buf)
 
 	for _, file := range files {
-		fmt.Fprintln(os.Stderr, "adding:", file.Header.Name)
+		logFrom(ctx).Debug("adding", "file", file.Header.Name)
 
 		if err := tw.WriteHeader(file.Header); err != nil {
 			return nil, err
@@ -54,11 +55,11 @@ func MakeTar(files []LayerFile, layers []v1.Layer) (*bytes.Buffer, error) {
 			}
 
 			if _, ok := added[header.Name]; ok {
-				fmt.Fprintln(os.Stderr, "skipping:", header.Name)
+				logFrom(ctx).Debug("skipping", "file", header.Name)
 				continue
 			}
 
-			fmt.Fprintln(os.Stderr, "including prior:", header.Name)
+			logFrom(ctx).Debug("including prior", "file", header.Name)
 
 			if err := tw.WriteHeader(header); err != nil {
 				return nil, err
@@ -0,0 +1,31 @@
+package images
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/replicate/yolo/pkg/logging"
+)
+
+// the buffer size for reading and writing connections.  Layers are often
+// gigabytes, so the 4KB default means a lot of tiny reads and writes.
+const bufferSize = 256 << 10
+
+// registryTransport is shared by every request, so pulls and pushes of
+// several layers reuse the same connections
+var registryTransport http.RoundTripper = newTransport()
+
+func newTransport() *http.Transport {
+	t := remote.DefaultTransport.(*http.Transport).Clone()
+	t.ReadBufferSize = bufferSize
+	t.WriteBufferSize = bufferSize
+	// layers are already compressed, asking for gzip only costs cpu
+	t.DisableCompression = true
+	return t
+}
+
+// transport traces registry and api requests to the context's logger
+func transport(ctx context.Context) http.RoundTripper {
+	return logging.Transport(registryTransport, logFrom(ctx))
+}
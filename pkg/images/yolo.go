@@ -2,96 +2,200 @@ package images
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
 	"io"
-	"os"
 	"strings"
 	"time"
 
-	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/crane"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
-	"github.com/google/go-containerregistry/pkg/v1/stream"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
-func Yolo(baseRef string, dest string, files []LayerFile, schema string, commit string, env []string, session authn.Authenticator) (string, error) {
-	fmt.Fprintln(os.Stderr, "fetching metadata for", baseRef)
-	base, err := crane.Pull(baseRef, crane.WithAuth(session))
+// YoloOptions describes a yolo push
+type YoloOptions struct {
+	Config
+
+	// BaseRef is the image to add files to
+	BaseRef string
+	// Base is the image BaseRef points to, if it was already pulled
+	Base v1.Image
+	// Dest is where the result is pushed
+	Dest  string
+	Files []LayerFile
+	// Schema replaces the openapi schema label, if set
+	Schema string
+	Commit string
+	Env    []string
+	// Stack keeps prior yolo layers and only adds Files in a new layer on
+	// top, instead of merging them into one layer
+	Stack bool
+	// IfMatch aborts the push unless Dest still points to this digest
+	IfMatch string
+}
+
+// YoloResult describes the pushed image
+type YoloResult struct {
+	ImageId string
+	Digest  string
+	// Base is the digest of the base image the files were added to
+	Base string
+	// Files is how many files were added; Unchanged were already in the base
+	Files     int
+	Unchanged int
+}
+
+// Yolo adds files to a base image and pushes the result.  Cancelling ctx
+// stops any pull or push in flight.
+func Yolo(ctx context.Context, o YoloOptions) (*YoloResult, error) {
+	ctx = withConfig(ctx, o.Config)
+
+	var err error
+	base := o.Base
+	if base == nil {
+		logFrom(ctx).Info("fetching metadata", "ref", o.BaseRef)
+		base, err = pull(ctx, o.BaseRef, o.Session)
+		if err != nil {
+			return nil, fmt.Errorf("pulling %w", err)
+		}
+	}
+	base = cacheLayers(ctx, o.Session, base)
+
+	prov, err := newProvenance(ctx, o.BaseRef, base, o.Commit)
+	if err != nil {
+		return nil, fmt.Errorf("reading provenance: %w", err)
+	}
+
+	files, err := skipUnchanged(ctx, base, o.Files)
 	if err != nil {
-		return "", fmt.Errorf("pulling %w", err)
+		return nil, fmt.Errorf("comparing files to base: %w", err)
 	}
 
-	var img v1.Image
+	img := base
 
 	if len(files) > 0 {
-		yoloLess, err := removeYolo(base)
+		yoloLayers, err := GetSourceLayers(base, false, true)
 		if err != nil {
-			return "", fmt.Errorf("removing existing yolo layers: %w", err)
-		}
-
-		// try to parse the predictor if it's provided
-		if schema != "" {
-			yoloLess, err = updatePredictor(yoloLess, schema)
-			if err != nil {
-				return "", fmt.Errorf("updating predictor: %w", err)
-			}
+			return nil, fmt.Errorf("getting source layers: %w", err)
 		}
 
-		if len(env) > 0 {
-			yoloLess, err = updateEnv(yoloLess, env)
+		yoloLess := base
+		priorLayers := yoloLayers
+		if o.Stack {
+			priorLayers = nil
+		} else {
+			yoloLess, err = removeYolo(ctx, base)
 			if err != nil {
-				return "", fmt.Errorf("updating env: %w", err)
+				return nil, fmt.Errorf("removing existing yolo layers: %w", err)
 			}
 		}
 
-		if commit != "" {
-			yoloLess, err = updateCommit(yoloLess, commit)
-			if err != nil {
-				return "", fmt.Errorf("updating commit: %w", err)
-			}
+		yoloLess, err = updateConfig(ctx, yoloLess, o.Schema, o.Env, o.Commit)
+		if err != nil {
+			return nil, err
 		}
 
-		fmt.Fprintln(os.Stderr, "appending as new layer")
+		logFrom(ctx).Info("appending as new layer")
 
-		yoloLayers, err := GetSourceLayers(base, false, true)
+		newLayer, err := MakeTar(ctx, files, priorLayers)
 		if err != nil {
-			return "", fmt.Errorf("getting source layers: %w", err)
+			return nil, fmt.Errorf("making tar: %w", err)
 		}
 
-		newLayer, err := MakeTar(files, yoloLayers)
-		if err != nil {
-			return "", fmt.Errorf("making tar: %w", err)
+		if !o.Stack {
+			prov.Manifest = make(map[string]string)
+		} else if prov.Manifest == nil {
+			prov.Manifest, err = hashLayers(yoloLayers)
+			if err != nil {
+				return nil, fmt.Errorf("hashing yolo layers: %w", err)
+			}
+		}
+		if err := hashTar(bytes.NewReader(newLayer.Bytes()), prov.Manifest); err != nil {
+			return nil, fmt.Errorf("hashing layer: %w", err)
 		}
 
 		img, err = appendLayer(yoloLess, newLayer)
 		if err != nil {
-			return "", fmt.Errorf("appending %v: %w", newLayer, err)
+			return nil, fmt.Errorf("appending %v: %w", newLayer, err)
 		}
 	} else {
-		if len(env) > 0 {
-			img, err = updateEnv(base, env)
-			if err != nil {
-				return "", fmt.Errorf("updating env: %w", err)
-			}
+		img, err = updateConfig(ctx, base, o.Schema, o.Env, o.Commit)
+		if err != nil {
+			return nil, err
 		}
 	}
+
+	img, err = setProvenance(img, prov)
+	if err != nil {
+		return nil, fmt.Errorf("updating provenance: %w", err)
+	}
+
+	baseDigest, err := base.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHead(ctx, o.BaseRef, baseDigest.String(), o.Dest, o.IfMatch, o.Session); err != nil {
+		return nil, err
+	}
+
 	// --- pushing image
-	start := time.Now()
-	err = crane.Push(img, dest, crane.WithAuth(session))
+	err = push(ctx, img, o.Dest, o.Session, mountSource{o.BaseRef, base})
 	if err != nil {
-		return "", fmt.Errorf("pushing %s: %w", dest, err)
+		return nil, fmt.Errorf("pushing %s: %w", o.Dest, err)
 	}
-	fmt.Fprintln(os.Stderr, "pushing took", time.Since(start))
 
-	return ImageId(dest, img)
+	imageId, err := o.ImageId(o.Dest, img)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	return &YoloResult{
+		ImageId:   imageId,
+		Digest:    digest.String(),
+		Base:      baseDigest.String(),
+		Files:     len(files),
+		Unchanged: len(o.Files) - len(files),
+	}, nil
+}
+
+func updateConfig(ctx context.Context, img v1.Image, schema string, env []string, commit string) (v1.Image, error) {
+	var err error
+
+	// try to parse the predictor if it's provided
+	if schema != "" {
+		img, err = updatePredictor(ctx, img, schema)
+		if err != nil {
+			return nil, fmt.Errorf("updating predictor: %w", err)
+		}
+	}
+
+	if len(env) > 0 {
+		img, err = updateEnv(ctx, img, env)
+		if err != nil {
+			return nil, fmt.Errorf("updating env: %w", err)
+		}
+	}
+
+	if commit != "" {
+		img, err = updateCommit(img, commit)
+		if err != nil {
+			return nil, fmt.Errorf("updating commit: %w", err)
+		}
+	}
+
+	return img, nil
 }
 
 // All of this code is from pkg/v1/mutate - so we can add history and use a tarball
-func appendLayer(base v1.Image, tarball *bytes.Buffer) (v1.Image, error) {
+func appendLayer(base v1.Image, buf *bytes.Buffer) (v1.Image, error) {
 	baseMediaType, err := base.MediaType()
 	if err != nil {
 		return nil, fmt.Errorf("getting base image media type: %w", err)
@@ -102,16 +206,25 @@ func appendLayer(base v1.Image, tarball *bytes.Buffer) (v1.Image, error) {
 		layerType = types.OCILayer
 	}
 
-	layer := stream.NewLayer(io.NopCloser(tarball), stream.WithMediaType(layerType))
+	// unlike a stream.Layer, this can be read again when a push is retried
+	data := buf.Bytes()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}, tarball.WithMediaType(layerType), tarball.WithCompressedCaching)
+	if err != nil {
+		return nil, fmt.Errorf("creating layer: %w", err)
+	}
+
+	return mutate.Append(base, mutate.Addendum{Layer: layer, History: yoloHistory()})
+}
 
-	history := v1.History{
+func yoloHistory() v1.History {
+	return v1.History{
 		CreatedBy: "cp . /src # yolo",
 		Created:   v1.Time{Time: time.Now()},
 		Author:    "yolo",
 		Comment:   "",
 	}
-
-	return mutate.Append(base, mutate.Addendum{Layer: layer, History: history})
 }
 
 func updateCommit(img v1.Image, commit string) (v1.Image, error) {
@@ -125,14 +238,34 @@ func updateCommit(img v1.Image, commit string) (v1.Image, error) {
 	return mutate.Config(img, cfg.Config)
 }
 
-func updateEnv(base v1.Image, env []string) (v1.Image, error) {
+func updateLabels(img v1.Image, labels map[string]string) (v1.Image, error) {
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Config.Labels == nil {
+		cfg.Config.Labels = make(map[string]string)
+	}
+	for k, v := range labels {
+		if v == "" {
+			delete(cfg.Config.Labels, k)
+		} else {
+			cfg.Config.Labels[k] = v
+		}
+	}
+
+	return mutate.Config(img, cfg.Config)
+}
+
+func updateEnv(ctx context.Context, base v1.Image, env []string) (v1.Image, error) {
 	cfg, err := base.ConfigFile()
 	if err != nil {
 		return nil, err
 	}
 
 	for _, e := range env {
-		fmt.Fprintf(os.Stderr, "updating env: %s\n", e)
+		logFrom(ctx).Debug("updating env", "env", e)
 		key := e[:strings.Index(e, "=")]
 		found := false
 		for i, v := range cfg.Config.Env {
@@ -149,13 +282,13 @@ func updateEnv(base v1.Image, env []string) (v1.Image, error) {
 	return mutate.Config(base, cfg.Config)
 }
 
-func updatePredictor(img v1.Image, schema string) (v1.Image, error) {
+func updatePredictor(ctx context.Context, img v1.Image, schema string) (v1.Image, error) {
 	cfg, err := img.ConfigFile()
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Fprintln(os.Stderr, "updating predictor to schema with length", len(schema))
+	logFrom(ctx).Debug("updating predictor schema", "length", len(schema))
 
 	cfg.Config.Labels["org.cogmodel.openapi_schema"] = schema
 	cfg.Config.Labels["run.cog.openapi_schema"] = schema
@@ -165,7 +298,7 @@ func updatePredictor(img v1.Image, schema string) (v1.Image, error) {
 
 // we need to remove any existing yolo layers before adding more... otherwise
 // we'll end up with a bunch of yolo layers
-func removeYolo(orig v1.Image) (v1.Image, error) {
+func removeYolo(ctx context.Context, orig v1.Image) (v1.Image, error) {
 	layers, err := orig.Layers()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get layers for original: %w", err)
@@ -193,7 +326,7 @@ func removeYolo(orig v1.Image) (v1.Image, error) {
 				add.Layer = layers[idx]
 			}
 
-			fmt.Println("adding layer", add.Layer, "with history", h)
+			logFrom(ctx).Debug("keeping layer", "created_by", h.CreatedBy, "empty", h.EmptyLayer)
 			yololessImage, err = mutate.Append(yololessImage, add)
 			if err != nil {
 				return nil, fmt.Errorf("failed to add layer: %w", err)
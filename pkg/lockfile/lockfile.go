@@ -0,0 +1,86 @@
+package lockfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/replicate/yolo/pkg/errdefs"
+)
+
+// Filename is the default name of the lockfile, written next to the project
+const Filename = "yolo.lock"
+
+// Entry pins the base a destination is built from
+type Entry struct {
+	// Base is the reference as given on the command line
+	Base string `json:"base"`
+	// BaseDigest is the digest reference Base resolved to
+	BaseDigest  string `json:"base_digest"`
+	SchemaHash  string `json:"schema_hash,omitempty"`
+	YoloVersion string `json:"yolo_version"`
+	// DestDigest is the digest of the last push to the destination
+	DestDigest string `json:"dest_digest,omitempty"`
+}
+
+type Lockfile struct {
+	path string
+
+	// Destinations maps destination repositories to their pinned base
+	Destinations map[string]Entry `json:"destinations"`
+}
+
+// Load reads the lockfile at path, returning an empty lockfile if it
+// doesn't exist yet
+func Load(path string) (*Lockfile, error) {
+	l := &Lockfile{path: path, Destinations: make(map[string]Entry)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if l.Destinations == nil {
+		l.Destinations = make(map[string]Entry)
+	}
+
+	return l, nil
+}
+
+func (l *Lockfile) Save() error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(l.path, append(data, '\n'), 0644)
+}
+
+// Check returns an error if dest is pinned to a base other than baseDigest
+func (l *Lockfile) Check(dest string, baseDigest string) error {
+	entry, ok := l.Destinations[dest]
+	if !ok {
+		return errdefs.Errorf(errdefs.Conflict, "%s has no entry for %s, run yolo lock first", l.path, dest)
+	}
+	if entry.BaseDigest != baseDigest {
+		return errdefs.Errorf(errdefs.Conflict, "base resolves to %s but %s pins %s for %s, run yolo lock --update to bump it", baseDigest, l.path, entry.BaseDigest, dest)
+	}
+	return nil
+}
+
+func HashSchema(schema string) string {
+	if schema == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(schema))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,141 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// headers that carry credentials, and query parameters that may, matched
+// case-insensitively.  Registries redirect blob downloads to URLs presigned
+// for S3, GCS or Azure, whose signatures are as good as a token.
+var (
+	secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+	secretParams  = []string{
+		"token", "access_token", "refresh_token", "password",
+		"X-Amz-Signature", "X-Amz-Credential", "X-Amz-Security-Token",
+		"X-Goog-Signature", "X-Goog-Credential",
+		"sig", "se",
+	}
+)
+
+// Transport logs every request made through inner at LevelTrace, with
+// credentials redacted
+func Transport(inner http.RoundTripper, log *slog.Logger) http.RoundTripper {
+	if inner == nil {
+		inner = http.DefaultTransport
+	}
+	return &transport{inner: inner, log: log}
+}
+
+type transport struct {
+	inner http.RoundTripper
+	log   *slog.Logger
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if !t.log.Enabled(ctx, LevelTrace) {
+		return t.inner.RoundTrip(req)
+	}
+
+	t.log.Log(ctx, LevelTrace, "http request",
+		"method", req.Method,
+		"url", RedactURL(req.URL),
+		slog.Group("header", headerAttrs(req.Header)...))
+
+	start := time.Now()
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		t.log.Log(ctx, LevelTrace, "http error",
+			"method", req.Method,
+			"url", RedactURL(req.URL),
+			"error", err,
+			"duration", time.Since(start))
+		return resp, err
+	}
+
+	t.log.Log(ctx, LevelTrace, "http response",
+		"method", req.Method,
+		"url", RedactURL(req.URL),
+		"status", resp.StatusCode,
+		"duration", time.Since(start),
+		slog.Group("header", headerAttrs(resp.Header)...))
+	return resp, nil
+}
+
+// RedactURL returns u as a string with passwords and token parameters
+// replaced
+func RedactURL(u *url.URL) string {
+	r := *u
+	if r.User != nil {
+		if _, ok := r.User.Password(); ok {
+			r.User = url.UserPassword(r.User.Username(), "REDACTED")
+		}
+	}
+
+	q := r.Query()
+	redacted := false
+	for key := range q {
+		if isSecretParam(key) {
+			q.Set(key, "REDACTED")
+			redacted = true
+		}
+	}
+	if redacted {
+		r.RawQuery = q.Encode()
+	}
+
+	return r.String()
+}
+
+func isSecretParam(key string) bool {
+	for _, p := range secretParams {
+		if strings.EqualFold(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactHeader returns the value of a header with any credential replaced,
+// keeping the scheme, like "Bearer REDACTED".  Redirects are redacted like
+// request URLs.
+func RedactHeader(key string, value string) string {
+	if http.CanonicalHeaderKey(key) == "Location" {
+		if u, err := url.Parse(value); err == nil {
+			return RedactURL(u)
+		}
+		return value
+	}
+
+	for _, h := range secretHeaders {
+		if http.CanonicalHeaderKey(key) != h {
+			continue
+		}
+		if scheme, _, found := strings.Cut(value, " "); found && h != "Cookie" && h != "Set-Cookie" {
+			return scheme + " REDACTED"
+		}
+		return "REDACTED"
+	}
+	return value
+}
+
+func headerAttrs(h http.Header) []any {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var attrs []any
+	for _, key := range keys {
+		for _, v := range h[key] {
+			attrs = append(attrs, slog.String(key, RedactHeader(key, v)))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		secret []string
+		kept   []string
+	}{
+		{
+			name:   "s3",
+			url:    "https://bucket.s3.amazonaws.com/blobs/abc?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=AKIAEXAMPLE%2F20240101%2Fus-east-1%2Fs3%2Faws4_request&X-Amz-Date=20240101T000000Z&X-Amz-Expires=1200&X-Amz-Security-Token=FwoGZXIvYXdzEXAMPLE&X-Amz-SignedHeaders=host&X-Amz-Signature=0123456789abcdef",
+			secret: []string{"AKIAEXAMPLE", "FwoGZXIvYXdzEXAMPLE", "0123456789abcdef"},
+			kept:   []string{"X-Amz-Expires=1200", "/blobs/abc"},
+		},
+		{
+			name:   "s3 lower case",
+			url:    "https://bucket.s3.amazonaws.com/blobs/abc?x-amz-signature=0123456789abcdef",
+			secret: []string{"0123456789abcdef"},
+		},
+		{
+			name:   "gcs",
+			url:    "https://storage.googleapis.com/bucket/abc?X-Goog-Algorithm=GOOG4-RSA-SHA256&X-Goog-Credential=svc%40example.iam.gserviceaccount.com&X-Goog-Signature=fedcba9876543210",
+			secret: []string{"svc%40example", "fedcba9876543210"},
+		},
+		{
+			name:   "azure",
+			url:    "https://account.blob.core.windows.net/container/abc?sv=2021-08-06&se=2024-01-01T00%3A00%3A00Z&sr=b&sp=r&sig=c2VjcmV0c2lnbmF0dXJl",
+			secret: []string{"c2VjcmV0c2lnbmF0dXJl", "2024-01-01T00"},
+			kept:   []string{"sv=2021-08-06"},
+		},
+		{
+			name:   "token",
+			url:    "https://r8.im/v2/token?scope=repository%3Aacme%2Fmodel%3Apull&token=hunter2",
+			secret: []string{"hunter2"},
+			kept:   []string{"scope="},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := RedactURL(u)
+			for _, s := range tt.secret {
+				if strings.Contains(got, s) {
+					t.Errorf("RedactURL kept %q: %s", s, got)
+				}
+			}
+			for _, s := range tt.kept {
+				if !strings.Contains(got, s) {
+					t.Errorf("RedactURL dropped %q: %s", s, got)
+				}
+			}
+
+			if got := RedactHeader("location", tt.url); strings.Contains(got, tt.secret[0]) {
+				t.Errorf("RedactHeader kept %q in a redirect: %s", tt.secret[0], got)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	barWidth    = 30
+	redrawEvery = 100 * time.Millisecond
+)
+
+// Timing is how long one phase of a command took
+type Timing struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Tracker renders per-layer transfer bars and records phase timings.  On a
+// TTY the bars are redrawn in place; otherwise a line is printed at every
+// quarter of each transfer.  A nil *Tracker discards everything.
+type Tracker struct {
+	// Quiet hides the bars and timings; warnings are still printed
+	Quiet bool
+
+	out io.Writer
+	tty bool
+
+	mu       sync.Mutex
+	bars     []*Bar
+	drawn    int
+	lastDraw time.Time
+	timings  []Timing
+	warnings []string
+}
+
+// Transfer summarizes a finished transfer
+type Transfer struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Sent is how many bytes were actually uploaded, 0 unless the registry
+	// needed the blob
+	Sent int64 `json:"sent"`
+	// Skipped is set if the registry already had the blob
+	Skipped bool `json:"skipped"`
+	// Mounted is set if the registry copied the blob from another repository
+	Mounted bool `json:"mounted"`
+}
+
+func New(f *os.File) *Tracker {
+	return &Tracker{out: f, tty: isTerminal(f)}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Phase starts timing a phase; call the returned func when it ends
+func (t *Tracker) Phase(name string) func() {
+	if t == nil {
+		return func() {}
+	}
+
+	start := time.Now()
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		// repeated phases, like several pulls, are added up
+		for i := range t.timings {
+			if t.timings[i].Name == name {
+				t.timings[i].Duration += time.Since(start)
+				return
+			}
+		}
+		t.timings = append(t.timings, Timing{Name: name, Duration: time.Since(start)})
+	}
+}
+
+func (t *Tracker) Timings() []Timing {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Timing(nil), t.timings...)
+}
+
+// Summary prints how long each phase took
+func (t *Tracker) Summary() {
+	if t == nil || t.Quiet {
+		return
+	}
+
+	var parts []string
+	for _, p := range t.Timings() {
+		parts = append(parts, fmt.Sprintf("%s %s", p.Name, p.Duration.Round(time.Millisecond)))
+	}
+	if len(parts) > 0 {
+		fmt.Fprintln(t.out, "timings:", strings.Join(parts, ", "))
+	}
+}
+
+// Warn prints a warning and keeps it for the command's result
+func (t *Tracker) Warn(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	if t == nil {
+		fmt.Fprintln(os.Stderr, "warning:", msg)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.warnings = append(t.warnings, msg)
+	fmt.Fprintln(t.out, "warning:", msg)
+}
+
+func (t *Tracker) Warnings() []string {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.warnings...)
+}
+
+// Transfers returns every finished transfer
+func (t *Tracker) Transfers() []Transfer {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var transfers []Transfer
+	for _, b := range t.bars {
+		if b.done && b.err == nil {
+			t := Transfer{Name: b.name, Size: b.total, Skipped: b.skipped, Mounted: b.mounted}
+			if !b.skipped && !b.mounted {
+				t.Sent = b.complete
+			}
+			transfers = append(transfers, t)
+		}
+	}
+	return transfers
+}
+
+// Bar is the progress of a single transfer
+type Bar struct {
+	t     *Tracker
+	name  string
+	start time.Time
+
+	total    int64
+	complete int64
+	done     bool
+	skipped  bool
+	mounted  bool
+	err      error
+	reported int64
+}
+
+// Start adds a bar for a transfer of total bytes.  Report progress with Add
+// and end it with Done, Skip, Mount or Fail.  A nil *Tracker returns a nil *Bar,
+// which discards everything too.
+func (t *Tracker) Start(name string, total int64) *Bar {
+	if t == nil {
+		return nil
+	}
+
+	b := &Bar{t: t, name: name, start: time.Now(), total: total}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bars = append(t.bars, b)
+	t.render(false)
+	return b
+}
+
+// Add records n more bytes transferred
+func (b *Bar) Add(n int64) {
+	if b == nil {
+		return
+	}
+
+	b.t.mu.Lock()
+	defer b.t.mu.Unlock()
+	b.complete += n
+	b.t.render(false)
+}
+
+// Done finishes a transfer
+func (b *Bar) Done() {
+	b.finish(func() {})
+}
+
+// Skip finishes a transfer the registry already had
+func (b *Bar) Skip() {
+	b.finish(func() { b.skipped = true })
+}
+
+// Mount finishes a transfer the registry mounted from another repository
+// instead of receiving it
+func (b *Bar) Mount() {
+	b.finish(func() {
+		b.mounted = true
+		b.complete = b.total
+	})
+}
+
+// Fail finishes a transfer that failed
+func (b *Bar) Fail(err error) {
+	b.finish(func() { b.err = err })
+}
+
+// Retry starts a failed transfer over on the same bar, so that it is only
+// listed once in Transfers
+func (b *Bar) Retry() {
+	if b == nil {
+		return
+	}
+
+	b.t.mu.Lock()
+	defer b.t.mu.Unlock()
+	b.start = time.Now()
+	b.complete = 0
+	b.done = false
+	b.err = nil
+	b.reported = 0
+	b.t.render(true)
+}
+
+func (b *Bar) finish(set func()) {
+	if b == nil {
+		return
+	}
+
+	b.t.mu.Lock()
+	defer b.t.mu.Unlock()
+	set()
+	b.done = true
+	b.t.render(true)
+}
+
+// must be called with t.mu held
+func (t *Tracker) render(force bool) {
+	if t.Quiet {
+		return
+	}
+	if !t.tty {
+		for _, b := range t.bars {
+			if q := b.quarter(); q > b.reported || (b.done && b.reported < 4) {
+				b.reported = q
+				if b.done {
+					b.reported = 4
+				}
+				fmt.Fprintln(t.out, b.line())
+			}
+		}
+		return
+	}
+
+	if !force && time.Since(t.lastDraw) < redrawEvery {
+		return
+	}
+	t.lastDraw = time.Now()
+
+	if t.drawn > 0 {
+		fmt.Fprintf(t.out, "\033[%dA", t.drawn)
+	}
+	for _, b := range t.bars {
+		fmt.Fprintf(t.out, "\r\033[K%s\n", b.line())
+	}
+	t.drawn = len(t.bars)
+}
+
+// quarter returns how many quarters of an unfinished transfer are complete
+func (b *Bar) quarter() int64 {
+	if b.total == 0 {
+		return 0
+	}
+	if b.complete >= b.total {
+		// reported as done once the transfer finishes
+		return 0
+	}
+	return b.complete * 4 / b.total
+}
+
+func (b *Bar) line() string {
+	if b.err != nil {
+		return fmt.Sprintf("%s  failed: %v", b.name, b.err)
+	}
+
+	filled := 0
+	if b.total > 0 {
+		filled = int(b.complete * barWidth / b.total)
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+
+	elapsed := time.Since(b.start).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(b.complete) / elapsed
+	}
+
+	if b.skipped {
+		return fmt.Sprintf("%s  already exists", b.name)
+	}
+	if b.mounted {
+		return fmt.Sprintf("%s  mounted %s", b.name, humanize.Bytes(uint64(b.total)))
+	}
+
+	status := "done"
+	if !b.done {
+		status = "ETA ?"
+		if rate > 0 {
+			eta := time.Duration(float64(b.total-b.complete) / rate * float64(time.Second))
+			status = "ETA " + eta.Round(time.Second).String()
+		}
+	}
+
+	return fmt.Sprintf("%s [%s] %s / %s  %s/s  %s",
+		b.name, bar,
+		humanize.Bytes(uint64(b.complete)), humanize.Bytes(uint64(b.total)),
+		humanize.Bytes(uint64(rate)), status)
+}
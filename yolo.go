@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/replicate/yolo/pkg/cli"
+	"github.com/replicate/yolo/pkg/errdefs"
 )
 
 func main() {
@@ -14,8 +18,18 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = cmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	// the first Ctrl-C cancels pulls and pushes in flight, a second one
+	// kills yolo as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err = cmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		cli.PrintError(err)
+		os.Exit(errdefs.ExitCode(err))
 	}
 }